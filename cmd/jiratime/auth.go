@@ -6,79 +6,279 @@ import (
 	"net/http"
 
 	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/cache"
 	"github.com/smlx/jiratime/internal/client"
 	"github.com/smlx/jiratime/internal/config"
-	"golang.org/x/oauth2"
+	"golang.org/x/exp/slog"
 )
 
-// getJiraClient constructs an authenticated Jira client.
-func getJiraClient(
-	ctx context.Context,
-	jiraURL string,
+// Authentication modes selected by authMode.
+const (
+	authModeOAuth2    = "oauth2"
+	authModeBasicAuth = "basic auth"
+	authModePAT       = "personal access token"
+)
+
+// authMode returns the authentication mode used for the deployment in the
+// given config. On Jira Cloud OAuth2 is used unless basic auth is requested,
+// or only basic auth is configured. On Jira Data Center personal access
+// tokens are used unless basic auth is requested, or only basic auth is
+// configured. The credential store is only checked for basic auth if
+// required, since an external helper may prompt the user.
+func authMode(
+	conf *config.Config,
+	store config.CredentialStore,
 	basicAuthFlag bool,
-) (*jira.Client, string, func() error, error) {
-	useBasicAuth := basicAuthFlag || (config.HasBasicAuth() && !config.HasAuth())
+) string {
+	if conf.Deployment == config.DeploymentDataCenter {
+		if basicAuthFlag ||
+			(!conf.HasPersonalAccessToken() && store.HasBasicAuth()) {
+			return authModeBasicAuth
+		}
+		return authModePAT
+	}
+	if basicAuthFlag || (!conf.HasAuth() && store.HasBasicAuth()) {
+		return authModeBasicAuth
+	}
+	return authModeOAuth2
+}
 
-	var httpClient *http.Client
-	var err error
-	var tokenSource oauth2.TokenSource
-	var auth *config.OAuth2
-	var userEmail string
-	var scoped bool
+// siteCache is the cache entry of a Jira site.
+type siteCache struct {
+	key  string
+	site *cache.Site
+}
 
-	if useBasicAuth {
-		httpClient, userEmail, scoped, err = client.NewBasicAuthHTTPClient()
+// getSiteCache returns the cache entry of the Jira site in the given config,
+// as seen by the user of the credentials used in the given mode. The
+// credentials are hashed into the cache key. It returns nil if requests are
+// traced according to the given Globals, so that traces are complete, or if
+// the cache can't be read.
+func getSiteCache(
+	log *slog.Logger,
+	g *Globals,
+	conf *config.Config,
+	store config.CredentialStore,
+	mode string,
+) *siteCache {
+	if g.Trace != "" || g.Replay != "" {
+		return nil
+	}
+	var identity string
+	switch mode {
+	case authModeBasicAuth:
+		ba, err := store.ReadBasicAuth()
 		if err != nil {
-			return nil, "", nil, fmt.Errorf("couldn't construct basic auth HTTP client: %v", err)
+			return nil
 		}
-		if scoped {
-			jiraURL, err = client.CloudIDJiraURL(httpClient, jiraURL)
-			if err != nil {
-				return nil, "", nil, fmt.Errorf("couldn't construct OAuth2 Jira URL: %v", err)
-			}
+		identity = fmt.Sprintf("%s\n%s\n%t", mode, ba.User, ba.Scoped)
+	case authModeOAuth2:
+		// the refresh token identifies the user who authorized jiratime, while
+		// the client ID only identifies the app. It is rotated on refresh, which
+		// causes at most one cache miss per refresh.
+		auth, err := store.ReadOAuth2()
+		if err != nil || auth == nil || auth.Token == nil {
+			return nil
 		}
-	} else {
-		httpClient, tokenSource, auth, err = client.NewOAuth2HTTPClient(ctx)
+		identity = mode + "\n" + auth.Token.RefreshToken
+	case authModePAT:
+		pat, err := conf.ReadPersonalAccessToken()
 		if err != nil {
-			return nil, "", nil, fmt.Errorf("couldn't construct OAuth2 HTTP client: %v", err)
+			return nil
 		}
+		identity = mode + "\n" + pat.Token
+	}
+	key := cache.Key(conf.JiraURL, identity)
+	site, err := cache.Read(key)
+	if err != nil {
+		log.Debug("couldn't read site cache", slog.Any("error", err))
+		return nil
+	}
+	return &siteCache{key: key, site: site}
+}
 
-		jiraURL, err = client.CloudIDJiraURL(httpClient, jiraURL)
-		if err != nil {
-			return nil, "", nil, fmt.Errorf("couldn't construct OAuth2 Jira URL: %v", err)
+// newJiraClient constructs a Jira client for the deployment in the given
+// config, authenticated using the given mode. It also returns the cloud ID
+// of the Jira Cloud site, if it was required to connect. Requests are traced
+// according to the given Globals. If sc is not nil, the cloud ID is read from
+// and stored in it, and it is invalidated by failed requests.
+func newJiraClient(
+	ctx context.Context,
+	log *slog.Logger,
+	g *Globals,
+	conf *config.Config,
+	store config.CredentialStore,
+	mode string,
+	sc *siteCache,
+) (client.Jira, string, error) {
+	if conf.Deployment == config.DeploymentDataCenter {
+		j, err := getDataCenterClient(log, g, conf, store,
+			mode == authModeBasicAuth, sc)
+		return j, "", err
+	}
+	return getCloudClient(ctx, log, g, conf.JiraURL, conf.Network, store,
+		mode == authModeBasicAuth, sc)
+}
+
+// getJiraClient constructs an authenticated Jira client for the deployment
+// in the given config. It returns the client and the account ID of the
+// authenticated user. Requests are traced according to the given Globals.
+// Credentials aren't used when replaying requests.
+func getJiraClient(
+	ctx context.Context,
+	log *slog.Logger,
+	g *Globals,
+	conf *config.Config,
+	basicAuthFlag bool,
+) (client.Jira, string, error) {
+	store := conf.CredentialStore()
+	var mode string
+	if g.Replay == "" {
+		mode = authMode(conf, store, basicAuthFlag)
+	}
+	sc := getSiteCache(log, g, conf, store, mode)
+	j, _, err := newJiraClient(ctx, log, g, conf, store, mode, sc)
+	if err != nil {
+		return nil, "", err
+	}
+	if sc != nil && sc.site.AccountID != "" {
+		log.Debug("using cached account ID")
+		return j, sc.site.AccountID, nil
+	}
+	// identify the user by account ID, since email addresses may be hidden
+	user, err := j.Myself(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't get current user: %v", err)
+	}
+	if user.AccountID == "" {
+		return nil, "", fmt.Errorf("current user has no account ID")
+	}
+	if sc != nil {
+		sc.site.AccountID = user.AccountID
+		if err = cache.Write(sc.key, sc.site); err != nil {
+			log.Debug("couldn't write site cache", slog.Any("error", err))
 		}
 	}
+	return j, user.AccountID, nil
+}
 
-	c, err := jira.NewClient(jiraURL, httpClient)
+// getCloudClient constructs an authenticated Jira Cloud client. It also
+// returns the cloud ID of the site if the API gateway is used, which is the
+// case for OAuth2 and scoped API tokens. When replaying requests the client
+// is unauthenticated, and the trace shows whether the gateway is used.
+func getCloudClient(
+	ctx context.Context,
+	log *slog.Logger,
+	g *Globals,
+	jiraURL string,
+	network *config.Network,
+	store config.CredentialStore,
+	useBasicAuth bool,
+	sc *siteCache,
+) (client.Jira, string, error) {
+	wrap, err := g.transportWrapper()
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("couldn't get new Jira client: %v", err)
+		return nil, "", fmt.Errorf("couldn't trace HTTP client: %v", err)
 	}
+	var httpClient *http.Client
+	var gateway bool
 
-	if !useBasicAuth {
-		user, _, err := c.User.GetCurrentUser(ctx)
+	switch {
+	case g.Replay != "":
+		httpClient, err = client.NewHTTPClient(log, network, wrap)
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't construct HTTP client: %v", err)
+		}
+		gateway, err = g.replayedGateway(jiraURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't check replayed trace: %v", err)
+		}
+	case useBasicAuth:
+		httpClient, gateway, err = client.NewBasicAuthHTTPClient(log, network,
+			store, wrap)
 		if err != nil {
-			return nil, "", nil, fmt.Errorf("couldn't get current user for OAuth2: %v", err)
+			return nil, "", fmt.Errorf("couldn't construct basic auth HTTP client: %v", err)
 		}
-		userEmail = user.EmailAddress
+	default:
+		httpClient, err = client.NewOAuth2HTTPClient(ctx, log, network, store,
+			wrap)
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't construct OAuth2 HTTP client: %v", err)
+		}
+		gateway = true
+	}
+	if sc != nil {
+		cache.Invalidate(httpClient, sc.key)
 	}
 
-	persistToken := func() error {
-		if !useBasicAuth {
-			newTok, err := tokenSource.Token()
+	var cloudID string
+	if gateway {
+		if sc != nil && sc.site.CloudID != "" {
+			log.Debug("using cached cloud ID")
+			cloudID = sc.site.CloudID
+		} else {
+			cloudID, err = client.CloudID(httpClient, jiraURL)
 			if err != nil {
-				return fmt.Errorf("couldn't get Token from oauth2.TokenSource: %v", err)
+				return nil, "", fmt.Errorf("couldn't construct OAuth2 Jira URL: %v", err)
 			}
-			err = config.WriteAuth(&config.OAuth2{
-				ClientID: auth.ClientID,
-				Secret:   auth.Secret,
-				Token:    newTok,
-			})
-			if err != nil {
-				return fmt.Errorf("couldn't persist new token: %v", err)
+			if sc != nil {
+				sc.site.CloudID = cloudID
 			}
 		}
-		return nil
+		jiraURL = client.GatewayURL(cloudID)
+	}
+
+	c, err := jira.NewClient(jiraURL, httpClient)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't get new Jira client: %v", err)
 	}
 
-	return c, userEmail, persistToken, nil
+	return client.NewJira(c), cloudID, nil
+}
+
+// getDataCenterClient constructs an authenticated Jira Data Center client.
+// When replaying requests the client is unauthenticated.
+func getDataCenterClient(
+	log *slog.Logger,
+	g *Globals,
+	conf *config.Config,
+	store config.CredentialStore,
+	useBasicAuth bool,
+	sc *siteCache,
+) (client.Jira, error) {
+	wrap, err := g.transportWrapper()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't trace HTTP client: %v", err)
+	}
+	var httpClient *http.Client
+	switch {
+	case g.Replay != "":
+		httpClient, err = client.NewHTTPClient(log, conf.Network, wrap)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't construct HTTP client: %v", err)
+		}
+	case useBasicAuth:
+		httpClient, _, err = client.NewBasicAuthHTTPClient(log, conf.Network,
+			store, wrap)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't construct basic auth HTTP client: %v", err)
+		}
+	default:
+		pat, err := conf.ReadPersonalAccessToken()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read personal access token: %v", err)
+		}
+		httpClient, err = client.NewPATHTTPClient(log, conf.Network, pat, wrap)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't construct personal access token HTTP client: %v", err)
+		}
+	}
+	if sc != nil {
+		cache.Invalidate(httpClient, sc.key)
+	}
+	c, err := jira.NewClient(conf.JiraURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get new Jira client: %v", err)
+	}
+	return client.NewDataCenterJira(c), nil
 }
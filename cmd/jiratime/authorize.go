@@ -1,51 +1,242 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/smlx/jiratime/internal/cache"
 	"github.com/smlx/jiratime/internal/client"
 	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
 	"golang.org/x/oauth2"
 )
 
+// shutdownTimeout is the maximum time allowed for the redirect server to
+// finish responding to the browser before it is closed.
+const shutdownTimeout = 5 * time.Second
+
 // AuthorizeCmd represents the `authorize` or `auth` command.
-type AuthorizeCmd struct{}
+type AuthorizeCmd struct {
+	Open    bool          `kong:"help='open the authorization URL in a web browser'"`
+	Manual  bool          `kong:"help='read the redirect URL from stdin instead of listening for it, for use when the browser is on another machine'"`
+	Timeout time.Duration `kong:"default=5m,help='maximum duration allowed to complete authorization'"`
+}
+
+// openURL opens the given URL in a web browser. It is a variable so that it
+// can be replaced in tests.
+var openURL = openBrowser
+
+// errInvalidState is returned by parseRedirect if the redirect doesn't have
+// the expected state.
+var errInvalidState = errors.New("invalid state")
+
+// redirectResult is the outcome of an OAuth2 authorization redirect.
+type redirectResult struct {
+	code string
+	err  error
+}
+
+// parseRedirect returns the authorization code in the query of an OAuth2
+// redirect, after checking that it has the given state. If authorization was
+// denied or failed, the OAuth2 error is returned.
+func parseRedirect(query url.Values, state string) (string, error) {
+	if got := query.Get("state"); got != state {
+		return "", fmt.Errorf(`%w: expected "%s", got "%s"`,
+			errInvalidState, state, got)
+	}
+	if oauthErr := query.Get("error"); oauthErr != "" {
+		if desc := query.Get("error_description"); desc != "" {
+			return "", fmt.Errorf("authorization failed: %s: %s", oauthErr, desc)
+		}
+		return "", fmt.Errorf("authorization failed: %s", oauthErr)
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("missing code")
+	}
+	return code, nil
+}
+
+// parsePastedRedirect returns the authorization code in the given redirect
+// URL pasted by the user, after checking its state. The user may paste just
+// the code instead of the URL.
+func parsePastedRedirect(input, state string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", fmt.Errorf("missing code")
+	}
+	if !strings.Contains(input, "?") {
+		return input, nil
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse redirect URL: %v", err)
+	}
+	return parseRedirect(u.Query(), state)
+}
+
+// readRedirect reads the redirect URL pasted by the user from r, and returns
+// the authorization code in it.
+func readRedirect(ctx context.Context, r io.Reader, state string) (string, error) {
+	c := make(chan redirectResult, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			c <- redirectResult{err: fmt.Errorf("couldn't read redirect URL: %v", err)}
+			return
+		}
+		code, err := parsePastedRedirect(line, state)
+		c <- redirectResult{code: code, err: err}
+	}()
+	select {
+	case result := <-c:
+		return result.code, result.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out waiting for code")
+	}
+}
+
+// awaitRedirect returns the authorization code received by the redirect
+// server, which is then shut down.
+func awaitRedirect(
+	ctx context.Context,
+	log *slog.Logger,
+	shutdown func(context.Context) error,
+	c <-chan redirectResult,
+) (string, error) {
+	var result redirectResult
+	select {
+	case result = <-c:
+	case <-ctx.Done():
+		result.err = fmt.Errorf("timed out waiting for code")
+	}
+	// let the redirect response reach the browser before shutting down
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(),
+		shutdownTimeout)
+	defer cancelShutdown()
+	if err := shutdown(shutdownCtx); err != nil {
+		log.Warn("couldn't shut down redirect server", slog.Any("error", err))
+	}
+	return result.code, result.err
+}
+
+// loopbackAddrs returns the addresses to listen on for redirects to the given
+// URL, which must be a http URL on a loopback address.
+func loopbackAddrs(u *url.URL) ([]string, error) {
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("redirect URL scheme must be http")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		// browsers may resolve localhost to either address
+		return []string{
+			net.JoinHostPort("127.0.0.1", port),
+			net.JoinHostPort("::1", port),
+		}, nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("redirect URL host %s is not a loopback address",
+			host)
+	}
+	return []string{net.JoinHostPort(host, port)}, nil
+}
 
-func startRedirectServer(ctx context.Context, state string, c chan<- string) {
+// startRedirectServer starts a server on the loopback interface which handles
+// the OAuth2 redirect to the given URL. The result of the first redirect with
+// the given state is sent to c, which must be buffered. It returns a function
+// which gracefully shuts the server down.
+func startRedirectServer(
+	log *slog.Logger,
+	redirectURL string,
+	state string,
+	c chan<- redirectResult,
+) (func(context.Context) error, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse redirect URL: %v", err)
+	}
+	addrs, err := loopbackAddrs(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL: %v", err)
+	}
+	var listeners []net.Listener
+	for i, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			if i > 0 {
+				// the system may not support IPv6
+				log.Debug("couldn't listen for OAuth2 redirect",
+					slog.String("address", addr), slog.Any("error", err))
+				continue
+			}
+			return nil, fmt.Errorf("couldn't listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	var once sync.Once
 	mux := http.NewServeMux()
-	// Create a new redirect route
-	mux.HandleFunc("/oauth/redirect", func(w http.ResponseWriter, r *http.Request) {
-		// first, check the state matches
-		if state != r.URL.Query().Get("state") {
-			fmt.Fprintf(os.Stderr, `invalid state: expected "%s", got "%s"`,
-				state, r.URL.Query().Get("state"))
-			w.WriteHeader(http.StatusBadRequest)
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		code, err := parseRedirect(r.URL.Query(), state)
+		if errors.Is(err, errInvalidState) {
+			// this may be a stray or forged request, so keep waiting
+			log.Warn("ignoring OAuth2 redirect", slog.Any("error", err))
+			http.Error(w, "Invalid state.", http.StatusBadRequest)
 			return
 		}
-		// First, we need to get the value of the `code` query param
-		err := r.ParseForm()
+		once.Do(func() { c <- redirectResult{code: code, err: err} })
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "could not parse query: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
+			http.Error(w, "Authorization failed. You may now close this page.",
+				http.StatusBadRequest)
 			return
 		}
-		c <- r.FormValue("code")
 		_, _ = w.Write([]byte("Authorization successful. You may now close this page."))
 	})
 	s := &http.Server{
-		Addr:        ":8080",
-		Handler:     mux,
-		BaseContext: func(_ net.Listener) context.Context { return ctx },
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	for _, l := range listeners {
+		go func() {
+			if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+				log.Error("couldn't serve OAuth2 redirect", slog.Any("error", err))
+			}
+		}()
+	}
+	return s.Shutdown, nil
+}
+
+// authConfig returns the network settings and credential store in the
+// config file. The config file is optional for OAuth2 commands, so the
+// defaults are returned if it doesn't exist.
+func authConfig() (*config.Network, config.CredentialStore, error) {
+	if !config.HasConfig() {
+		return nil, config.NewCredentialStore(nil, ""), nil
+	}
+	c, err := config.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read config: %v", err)
 	}
-	log.Println(s.ListenAndServe())
+	return c.Network, c.CredentialStore(), nil
 }
 
 // randomHex returns a string consisting of n hex-encoded random bytes. Because
@@ -59,11 +250,15 @@ func randomHex(n int) (string, error) {
 }
 
 // Run the Authorize command.
-func (cmd *AuthorizeCmd) Run() error {
-	ctx, cancel := getContext(30 * time.Second)
+func (cmd *AuthorizeCmd) Run(log *slog.Logger) error {
+	ctx, cancel := getContext(log, cmd.Timeout)
 	defer cancel()
-	// read the config file to get the oauth2 clientID and secret
-	auth, err := config.ReadAuth()
+	network, store, err := authConfig()
+	if err != nil {
+		return err
+	}
+	// read the oauth2 clientID and secret
+	auth, err := store.ReadOAuth2()
 	if err != nil {
 		return fmt.Errorf("couldn't load config: %v", err)
 	}
@@ -74,39 +269,66 @@ func (cmd *AuthorizeCmd) Run() error {
 	if auth.ClientID == "" || auth.Secret == "" {
 		return fmt.Errorf("missing ClientID or Secret in oauth2 configuration")
 	}
-	// generate a random state
+	// generate a random state, and a PKCE verifier
 	state, err := randomHex(16)
 	if err != nil {
 		return fmt.Errorf("couldn't generate state: %v", err)
 	}
+	verifier := oauth2.GenerateVerifier()
 	// get the OAuth2 config object
 	conf := client.GetOAuth2Config(auth)
+	// start the server to handle the redirect after authorization
+	c := make(chan redirectResult, 1)
+	var shutdown func(context.Context) error
+	if !cmd.Manual {
+		shutdown, err = startRedirectServer(log, conf.RedirectURL, state, c)
+		if err != nil {
+			return fmt.Errorf("couldn't start redirect server: %v", err)
+		}
+	}
 	// Redirect user to consent page to ask for permission
 	// for the scopes specified above.
 	url := conf.AuthCodeURL(state,
 		oauth2.SetAuthURLParam("audience", "api.atlassian.com"),
-		oauth2.SetAuthURLParam("prompt", "consent"))
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.S256ChallengeOption(verifier))
 	fmt.Printf("Visit this URL to authorize jiratime: %s\n", url)
-	// start the server to handle the redirect after authorization
-	c := make(chan string, 1)
-	go startRedirectServer(ctx, state, c)
+	if cmd.Open {
+		if err = openURL(url); err != nil {
+			log.Warn("couldn't open browser", slog.Any("error", err))
+		}
+	}
 	var code string
-	select {
-	case code = <-c:
-		// received code
-	case <-ctx.Done():
-		return fmt.Errorf("timed out waiting for code")
+	if cmd.Manual {
+		fmt.Print("After authorizing, your browser is redirected to a page" +
+			" which may fail to load.\nPaste the URL of that page here: ")
+		code, err = readRedirect(ctx, os.Stdin, state)
+	} else {
+		code, err = awaitRedirect(ctx, log, shutdown, c)
+	}
+	if err != nil {
+		return err
+	}
+	httpClient, err := client.NewHTTPClient(log, network, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't construct HTTP client: %v", err)
 	}
-	// use a custom HTTP client with a reasonable timeout
 	tok, err := conf.Exchange(
-		context.WithValue(ctx, oauth2.HTTPClient,
-			&http.Client{Timeout: 4 * time.Second}), code)
+		context.WithValue(ctx, oauth2.HTTPClient, httpClient), code,
+		oauth2.VerifierOption(verifier))
 	if err != nil {
 		return fmt.Errorf("couldn't exchange token: %v", err)
 	}
 	auth.Token = tok
-	if err = config.WriteAuth(auth); err != nil {
+	if scope, ok := tok.Extra("scope").(string); ok {
+		auth.Scope = scope
+	}
+	if err = store.WriteOAuth2(auth); err != nil {
 		return fmt.Errorf("couldn't write config: %v", err)
 	}
+	// a different user may have authorized jiratime
+	if err = cache.Clear(); err != nil {
+		log.Warn("couldn't clear site cache", slog.Any("error", err))
+	}
 	return nil
 }
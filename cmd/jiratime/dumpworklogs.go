@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/smlx/jiratime/internal/client"
@@ -16,38 +15,31 @@ type DumpWorklogsCmd struct {
 	Since     time.Time     `kong:"required,help='time from which the worklogs should be dumped'"`
 	Timeout   time.Duration `kong:"default=1h,help='maximum duration allowed for the command to return'"`
 	BasicAuth bool          `kong:"help='force basic auth instead of OAuth2'"`
+	Jiratime  bool          `kong:"help='only dump worklogs created by jiratime'"`
 }
 
 // Run the DumpWorklogs command.
-func (cmd *DumpWorklogsCmd) Run() error {
+func (cmd *DumpWorklogsCmd) Run(g *Globals, log *slog.Logger) error {
 	// global timeout of 60 seconds
-	ctx, cancel := getContext(cmd.Timeout)
+	ctx, cancel := getContext(log, cmd.Timeout)
 	defer cancel()
 	// read config file
 	conf, err := config.Read()
 	if err != nil {
 		return fmt.Errorf("couldn't load config: %v", err)
 	}
-	level := slog.LevelVar{}
-	level.Set(slog.LevelDebug)
-	log := slog.New(
-		slog.HandlerOptions{
-			AddSource: true,
-			Level:     &level,
-		}.NewJSONHandler(os.Stderr))
-
-	c, userEmail, persistToken, err := getJiraClient(ctx, conf.JiraURL, cmd.BasicAuth)
+	c, accountID, err := getJiraClient(ctx, log, g, conf, cmd.BasicAuth)
 	if err != nil {
 		return fmt.Errorf("couldn't get Jira client: %v", err)
 	}
 
 	// get the worklogs
-	worklogs, err := client.Worklogs(ctx, log, c, userEmail, cmd.Since)
+	worklogs, err := client.Worklogs(ctx, log, c, accountID, cmd.Since)
 	if err != nil {
 		return fmt.Errorf("couldn't dump worklogs: %v", err)
 	}
-	if err := persistToken(); err != nil {
-		return fmt.Errorf("couldn't persist token: %v", err)
+	if cmd.Jiratime {
+		worklogs = client.JiratimeWorklogs(worklogs)
 	}
 
 	data, err := json.Marshal(worklogs)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smlx/jiratime/internal/cache"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
+)
+
+// AuthLogoutCmd represents the `auth logout` command.
+type AuthLogoutCmd struct {
+	Timeout time.Duration `kong:"default=30s,help='maximum duration allowed for the command to return'"`
+}
+
+// revokeURL is the Atlassian account page where the user can revoke access
+// granted to jiratime. Atlassian doesn't document an OAuth2 token revocation
+// endpoint for 3LO apps, so logout can only remove the local token.
+const revokeURL = "https://id.atlassian.com/manage-profile/apps"
+
+// Run the AuthLogout command.
+func (cmd *AuthLogoutCmd) Run(log *slog.Logger) error {
+	ctx, cancel := getContext(log, cmd.Timeout)
+	defer cancel()
+	_, store, err := authConfig()
+	if err != nil {
+		return err
+	}
+	if config.HasAuth() {
+		if err = logoutOAuth2(ctx, log, store); err != nil {
+			return err
+		}
+	}
+	removed, err := config.RemoveCredentials()
+	for _, path := range removed {
+		log.Info("removed credentials", slog.String("path", path))
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't remove credentials: %v", err)
+	}
+	if err = cache.Clear(); err != nil {
+		return fmt.Errorf("couldn't clear site cache: %v", err)
+	}
+	return nil
+}
+
+// logoutOAuth2 removes the OAuth2 token from the given store. The token is
+// not revoked, so the user is told how to revoke it.
+func logoutOAuth2(
+	ctx context.Context,
+	log *slog.Logger,
+	store config.CredentialStore,
+) error {
+	// prevent a concurrent refresh from writing a new token
+	unlock, err := store.LockOAuth2(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't lock auth config: %v", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			log.Warn("couldn't unlock auth config", slog.Any("error", err))
+		}
+	}()
+	auth, err := store.ReadOAuth2()
+	if err != nil {
+		return fmt.Errorf("couldn't read OAuth2 config: %v", err)
+	}
+	if auth == nil || auth.Token == nil {
+		return nil
+	}
+	auth.Token = nil
+	auth.Scope = ""
+	if err = store.WriteOAuth2(auth); err != nil {
+		return fmt.Errorf("couldn't remove OAuth2 token: %v", err)
+	}
+	log.Info("removed OAuth2 token")
+	fmt.Printf("The OAuth2 token was removed from this machine but was not"+
+		" revoked.\nTo revoke jiratime's access, remove it from the connected"+
+		" apps in your Atlassian account: %s\n", revokeURL)
+	return nil
+}
@@ -3,27 +3,79 @@ package main
 
 import (
 	"context"
-	"log"
+	"io"
 	"os"
 	"os/signal"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/smlx/jiratime/internal/config"
+	"github.com/smlx/jiratime/internal/trace"
+	"golang.org/x/exp/slog"
 )
 
+// Globals are the flags shared by all commands.
+type Globals struct {
+	Trace  string `kong:"type=path,xor='trace',placeholder='FILE',help='record HTTP requests made by the Jira client to FILE, with credentials redacted'"`
+	Replay string `kong:"type=existingfile,xor='trace',placeholder='FILE',help='replay the Jira client responses recorded in FILE by --trace, instead of connecting to Jira'"`
+
+	Profile string `kong:"env='JIRATIME_PROFILE',placeholder='NAME',help='use the config and credentials of the named profile, stored in the profiles/NAME subdirectory of the jiratime config directory'"`
+
+	LogLevel  string `kong:"default='info',enum='debug,info,warn,error',help='minimum level of log messages (${enum})'"`
+	LogFormat string `kong:"default='text',enum='text,json',help='format of log messages (${enum})'"`
+
+	// recorder and replayer are shared by all the HTTP clients of a command.
+	recorder *trace.Recorder
+	replayer *trace.Replayer
+}
+
 // CLI represents the command-line interface.
 type CLI struct {
-	Submit       SubmitCmd       `kong:"cmd,default=1,help='(default) Submit times'"`
-	Authorize    AuthorizeCmd    `kong:"cmd,aliases='auth',help='Get OAuth2 client token'"`
-	DumpWorklogs DumpWorklogsCmd `kong:"cmd,help='Dump Worklog records in JSON format'"`
-	Version      VersionCmd      `kong:"cmd,help='Print version information'"`
+	Globals Globals `embed:""`
+
+	Submit          SubmitCmd          `kong:"cmd,default=1,help='(default) Submit times'"`
+	Authorize       AuthorizeCmd       `kong:"cmd,help='Get OAuth2 client token'"`
+	Auth            AuthCmd            `kong:"cmd,help='Manage authentication: authorize (default), status, logout'"`
+	DumpWorklogs    DumpWorklogsCmd    `kong:"cmd,help='Dump Worklog records in JSON format'"`
+	UpdateIssueKeys UpdateIssueKeysCmd `kong:"cmd,help='Rewrite config with the current keys of moved issues'"`
+	Version         VersionCmd         `kong:"cmd,help='Print version information'"`
+}
+
+// AuthCmd represents the `auth` command group.
+type AuthCmd struct {
+	Authorize AuthorizeCmd  `kong:"cmd,default='withargs',help='(default) Get OAuth2 client token'"`
+	Status    AuthStatusCmd `kong:"cmd,help='Check the credentials and report the authenticated account'"`
+	Logout    AuthLogoutCmd `kong:"cmd,help='Remove stored credentials'"`
+}
+
+// AfterApply selects the profile given by the global flags. It is called by
+// kong after the flags have been parsed.
+func (g *Globals) AfterApply() error {
+	return config.SetProfile(g.Profile)
+}
+
+// newLogger returns a logger which writes to w at the level and in the format
+// given by the global flags.
+func (g *Globals) newLogger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(g.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := slog.HandlerOptions{Level: level}
+	if g.LogFormat == "json" {
+		return slog.New(opts.NewJSONHandler(w))
+	}
+	return slog.New(opts.NewTextHandler(w))
 }
 
 // getContext starts a goroutine to handle ^C gracefully, and returns a
 // context configured with the given timeout, and a "cancel" function which
 // cleans up the signal handling and ensures the goroutine exits. This "cancel"
 // function should be deferred in main().
-func getContext(timeout time.Duration) (context.Context, func()) {
+func getContext(
+	log *slog.Logger,
+	timeout time.Duration,
+) (context.Context, func()) {
 	ctx, cancel := context.WithDeadline(context.Background(),
 		time.Now().Add(timeout))
 	signalChan := make(chan os.Signal, 1)
@@ -31,7 +83,7 @@ func getContext(timeout time.Duration) (context.Context, func()) {
 	go func() {
 		select {
 		case <-signalChan:
-			log.Println("exiting. ^C again to force.")
+			log.Warn("exiting. ^C again to force.")
 			cancel()
 		case <-ctx.Done():
 		}
@@ -47,6 +99,11 @@ func main() {
 	kctx := kong.Parse(&cli,
 		kong.UsageOnError(),
 	)
+	log := cli.Globals.newLogger(os.Stderr)
+	for _, path := range config.InsecureCredentialFiles() {
+		log.Warn("credential file is readable by other users."+
+			" Restrict it with `chmod 600`.", slog.String("path", path))
+	}
 	// execute CLI
-	kctx.FatalIfErrorf(kctx.Run())
+	kctx.FatalIfErrorf(kctx.Run(&cli.Globals, log))
 }
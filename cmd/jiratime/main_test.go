@@ -0,0 +1,1088 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/alecthomas/assert/v2"
+	"github.com/alecthomas/kong"
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/client/jiratest"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/oauth2"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	siteHost       = "example.atlassian.net"
+	cloudID        = "11111111-2222-3333-4444-555555555555"
+	dataCenterHost = "jira.example.com"
+)
+
+func TestMain(m *testing.M) {
+	// timesheet times are parsed in the local timezone
+	time.Local = time.UTC
+	os.Exit(m.Run())
+}
+
+// harness runs jiratime commands against a jiratest.Server, using a
+// temporary XDG_CONFIG_HOME.
+type harness struct {
+	t      *testing.T
+	dir    string
+	fake   *jiratest.Fake
+	server *jiratest.Server
+}
+
+// newHarness returns a harness with a single Jira site containing some
+// issues.
+func newHarness(t *testing.T) *harness {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	xdg.Reload()
+	f := jiratest.New()
+	f.AddIssue("ABC-1", "admin", "indeterminate")
+	f.AddIssue("ABC-2", "on-call", "new")
+	f.AddIssue("XYZ-3", "finished project", "done")
+	srv := jiratest.NewServer()
+	srv.AddSite(siteHost, cloudID, f)
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Transport()
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+		srv.Close()
+		// commands may select a profile
+		_ = config.SetProfile("")
+	})
+	return &harness{t: t, dir: dir, fake: f, server: srv}
+}
+
+// writeConfigFile writes the given file contents to the jiratime config
+// directory.
+func (h *harness) writeConfigFile(name, data string) {
+	path, err := xdg.ConfigFile(filepath.Join("jiratime", name))
+	assert.NoError(h.t, err, "config file path")
+	assert.NoError(h.t, os.WriteFile(path, []byte(data), 0600), "write "+name)
+}
+
+// removeCredentials removes the credential files of every profile from the
+// jiratime config directory.
+func (h *harness) removeCredentials() {
+	dir := filepath.Join(h.dir, "config", "jiratime")
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch d.Name() {
+		case "auth.yml", "basicauth.yml", "pat.yml", "tempo.yml":
+			return os.Remove(path)
+		}
+		return nil
+	})
+	assert.NoError(h.t, err, "remove credentials")
+}
+
+// writeConfig writes a config.yml for the Jira site.
+func (h *harness) writeConfig() {
+	h.writeConfigFile("config.yml", `jiraURL: https://`+siteHost+`/
+issues:
+- id: ABC-1
+  defaultComment: email / slack
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+`)
+}
+
+// writeDataCenterConfig writes a config.yml for a Jira Data Center site
+// serving the same issues as the Jira Cloud site.
+func (h *harness) writeDataCenterConfig() {
+	h.server.AddDataCenterSite(dataCenterHost, h.fake)
+	h.writeConfigFile("config.yml", `jiraURL: https://`+dataCenterHost+`/
+deployment: datacenter
+issues:
+- id: ABC-1
+  defaultComment: email / slack
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+`)
+}
+
+// writePAT writes a pat.yml.
+func (h *harness) writePAT() {
+	h.assertNoError(config.WritePersonalAccessToken(&config.PersonalAccessToken{
+		Token: h.server.PAT,
+	}), "write pat")
+}
+
+// writeBasicAuth writes a basicauth.yml.
+func (h *harness) writeBasicAuth(scoped bool) {
+	h.assertNoError(config.WriteBasicAuth(&config.BasicAuth{
+		User:   h.server.User,
+		APIKey: h.server.APIKey,
+		Scoped: scoped,
+	}), "write basic auth")
+}
+
+// writeAuth writes an auth.yml with the current OAuth2 tokens, expiring at
+// the given time.
+func (h *harness) writeAuth(expiry time.Time) {
+	access, refresh := h.server.Tokens()
+	h.assertNoError(config.WriteAuth(&config.OAuth2{
+		ClientID: h.server.ClientID,
+		Secret:   h.server.Secret,
+		Token: &oauth2.Token{
+			AccessToken:  access,
+			TokenType:    "Bearer",
+			RefreshToken: refresh,
+			Expiry:       expiry,
+		},
+	}), "write auth")
+}
+
+func (h *harness) assertNoError(err error, msg string) {
+	h.t.Helper()
+	assert.NoError(h.t, err, msg)
+}
+
+// addWorklog adds a worklog by the current user to the fake.
+func (h *harness) addWorklog(key string, started time.Time, comment string) {
+	jt := jira.Time(started)
+	_, err := h.fake.AddWorklog(context.Background(), key, &client.WorklogRecord{
+		WorklogRecord: jira.WorklogRecord{
+			Comment:          comment,
+			Started:          &jt,
+			TimeSpentSeconds: 1800,
+		},
+	})
+	h.assertNoError(err, "add worklog")
+}
+
+// run runs jiratime with the given arguments and stdin, returning the
+// output written to stdout.
+func (h *harness) run(stdin string, args ...string) (string, error) {
+	inPath := filepath.Join(h.dir, "stdin")
+	outPath := filepath.Join(h.dir, "stdout")
+	h.assertNoError(os.WriteFile(inPath, []byte(stdin), 0600), "write stdin")
+	in, err := os.Open(inPath)
+	h.assertNoError(err, "open stdin")
+	defer in.Close()
+	out, err := os.Create(outPath)
+	h.assertNoError(err, "create stdout")
+	defer out.Close()
+	stdin0, stdout0 := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, out
+	defer func() { os.Stdin, os.Stdout = stdin0, stdout0 }()
+	cli := CLI{}
+	parser, err := kong.New(&cli, kong.Name("jiratime"),
+		kong.Exit(func(int) { h.t.Fatalf("unexpected exit") }))
+	h.assertNoError(err, "new parser")
+	kctx, err := parser.Parse(args)
+	h.assertNoError(err, "parse args")
+	runErr := kctx.Run(&cli.Globals, cli.Globals.newLogger(io.Discard))
+	data, err := os.ReadFile(outPath)
+	h.assertNoError(err, "read stdout")
+	return string(data), runErr
+}
+
+// volatile matches values derived from today's date, which are replaced with
+// placeholders in golden files.
+var volatile = []struct {
+	re          *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`startedAfter=[0-9]+`), "startedAfter=MILLIS"},
+	{regexp.MustCompile(`"hash":"[0-9a-f]+"`), `"hash":"HASH"`},
+	{regexp.MustCompile(`"timesheetId":"[0-9a-f]+"`), `"timesheetId":"TIMESHEET"`},
+}
+
+// assertGolden compares the given data against the golden file with the
+// given name, or updates the golden file if the -update flag is set. Today's
+// date and values derived from it are replaced with placeholders as
+// timesheets are always for today.
+func (h *harness) assertGolden(name, data string) {
+	h.t.Helper()
+	data = strings.ReplaceAll(data, time.Now().Format("2006-01-02"), "TODAY")
+	for _, v := range volatile {
+		data = v.re.ReplaceAllString(data, v.placeholder)
+	}
+	path := filepath.Join("testdata", name)
+	if *update {
+		h.assertNoError(os.MkdirAll(filepath.Dir(path), 0755), "mkdir testdata")
+		h.assertNoError(os.WriteFile(path, []byte(data), 0644), "write golden")
+		return
+	}
+	golden, err := os.ReadFile(path)
+	h.assertNoError(err, "read golden")
+	assert.Equal(h.t, string(golden), data, name)
+}
+
+// assertRequests compares the requests made to the server against the
+// golden file with the given name.
+func (h *harness) assertRequests(name string) {
+	h.t.Helper()
+	h.assertGolden(name, strings.Join(h.server.Requests(), "\n\n")+"\n")
+}
+
+const timesheet = `0900-0945
+admin - TPS report cover sheet
+0945-1100
+ABC-2 - fighting fires
+1100-1200
+admin
+1200-1300
+lunch
+`
+
+func TestSubmit(t *testing.T) {
+	var testCases = map[string]struct {
+		setup     func(*harness)
+		stdin     string
+		args      []string
+		expectErr bool
+		expect    map[string]int
+	}{
+		"basic-auth": {
+			setup:  func(h *harness) { h.writeBasicAuth(false) },
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"basic-auth-scoped": {
+			setup:  func(h *harness) { h.writeBasicAuth(true) },
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"oauth2": {
+			setup:  func(h *harness) { h.writeAuth(time.Now().Add(time.Hour)) },
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"oauth2-refresh": {
+			setup:  func(h *harness) { h.writeAuth(time.Now().Add(-time.Hour)) },
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"datacenter-pat": {
+			setup: func(h *harness) {
+				h.writeDataCenterConfig()
+				h.writePAT()
+			},
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"datacenter-basic-auth": {
+			setup: func(h *harness) {
+				h.writeDataCenterConfig()
+				h.writeBasicAuth(false)
+			},
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"visibility": {
+			setup: func(h *harness) {
+				h.writeConfigFile("config.yml", `jiraURL: https://`+siteHost+`/
+issues:
+- id: ABC-1
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+visibility:
+- regexes:
+  - ^ABC-2$
+  role: Developers
+`)
+				h.writeBasicAuth(false)
+				h.fake.Groups = []string{"staff"}
+				h.fake.Roles = map[string][]string{"ABC": {"Developers"}}
+			},
+			stdin:  timesheet + "1300-1330\nadmin\n[group: staff]\n",
+			expect: map[string]int{"ABC-1": 3, "ABC-2": 1},
+		},
+		"env-credentials": {
+			setup: func(h *harness) {
+				h.writeConfigFile("config.yml", `jiraURL: https://`+siteHost+`/
+credentials:
+  store: env
+issues:
+- id: ABC-1
+  defaultComment: email / slack
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+`)
+				h.t.Setenv("JIRATIME_USER", h.server.User)
+				h.t.Setenv("JIRATIME_API_TOKEN", h.server.APIKey)
+			},
+			expect: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"dry-run": {
+			setup:  func(h *harness) { h.writeBasicAuth(false) },
+			args:   []string{"--dry-run"},
+			expect: map[string]int{"ABC-1": 0, "ABC-2": 0},
+		},
+		"all-ignored": {
+			setup:  func(h *harness) { h.writeBasicAuth(false) },
+			stdin:  "1200-1300\nlunch\n",
+			expect: map[string]int{"ABC-1": 0, "ABC-2": 0},
+		},
+		"time-tracking-disabled": {
+			setup: func(h *harness) {
+				h.writeBasicAuth(false)
+				h.fake.TimeTrackingDisabled = true
+			},
+			expectErr: true,
+			expect:    map[string]int{"ABC-1": 0, "ABC-2": 0},
+		},
+		"no-permission": {
+			setup: func(h *harness) {
+				h.writeBasicAuth(false)
+				h.fake.NoPermission = []string{"ABC"}
+			},
+			expectErr: true,
+			expect:    map[string]int{"ABC-1": 0, "ABC-2": 0},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfig()
+			tc.setup(h)
+			args := append([]string{"submit", "--concurrency=1"}, tc.args...)
+			stdin := tc.stdin
+			if stdin == "" {
+				stdin = timesheet
+			}
+			stdout, err := h.run(stdin, args...)
+			if tc.expectErr {
+				assert.Error(tt, err, "run")
+			} else {
+				assert.NoError(tt, err, "run")
+			}
+			assert.Equal(tt, "", stdout, "stdout")
+			for key, count := range tc.expect {
+				assert.Equal(tt, count, len(h.fake.Worklogs(key)), key)
+			}
+			h.assertRequests(filepath.Join("submit", name+".requests"))
+		})
+	}
+}
+
+func TestSubmitHelperOAuth2(t *testing.T) {
+	h := newHarness(t)
+	h.writeAuth(time.Now().Add(time.Hour))
+	requests := filepath.Join(h.dir, "helper.log")
+	h.writeConfigFile("config.yml", `jiraURL: https://`+siteHost+`/
+credentials:
+  store: helper
+  helper: 'f() { cat >> `+requests+`; echo username=`+h.server.ClientID+
+		`; echo password=`+h.server.Secret+`; }; f'
+issues:
+- id: ABC-1
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+`)
+	_, err := h.run(timesheet, "submit", "--dry-run")
+	assert.NoError(t, err, "run")
+	data, err := os.ReadFile(requests)
+	assert.NoError(t, err, "read helper log")
+	// the helper isn't asked for basic auth credentials which aren't used
+	assert.Contains(t, string(data), "kind=oauth2", "helper requests")
+	assert.NotContains(t, string(data), "kind=basicauth", "helper requests")
+}
+
+func TestSubmitPersistsRefreshedToken(t *testing.T) {
+	var testCases = map[string]struct {
+		noPermission []string
+		expectErr    bool
+	}{
+		"success": {},
+		// the rotated refresh token must be kept even if the command fails
+		"failure": {noPermission: []string{"ABC"}, expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfig()
+			h.writeAuth(time.Now().Add(-time.Hour))
+			h.fake.NoPermission = tc.noPermission
+			_, err := h.run(timesheet, "submit")
+			if tc.expectErr {
+				assert.Error(tt, err, "run")
+			} else {
+				assert.NoError(tt, err, "run")
+			}
+			auth, err := config.ReadAuth()
+			assert.NoError(tt, err, "read auth")
+			access, refresh := h.server.Tokens()
+			assert.Equal(tt, access, auth.Token.AccessToken, "access token")
+			assert.Equal(tt, refresh, auth.Token.RefreshToken, "refresh token")
+			assert.Equal(tt, h.server.ClientID, auth.ClientID, "client ID")
+		})
+	}
+}
+
+func TestSubmitSkipsDuplicates(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig()
+	h.writeBasicAuth(false)
+	for range 2 {
+		_, err := h.run(timesheet, "submit")
+		assert.NoError(t, err, "run")
+	}
+	assert.Equal(t, 2, len(h.fake.Worklogs("ABC-1")), "ABC-1")
+	assert.Equal(t, 1, len(h.fake.Worklogs("ABC-2")), "ABC-2")
+	// only jiratime worklogs are dumped with --jiratime
+	h.addWorklog("ABC-1", time.Now(), "manual")
+	stdout, err := h.run("", "dump-worklogs", "--jiratime",
+		"--since="+time.Now().AddDate(0, 0, -1).Format(time.RFC3339))
+	assert.NoError(t, err, "run")
+	var worklogs map[string][]jira.WorklogRecord
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &worklogs), "unmarshal")
+	assert.Equal(t, 2, len(worklogs["ABC-1"]), "dumped ABC-1")
+	assert.Equal(t, 1, len(worklogs["ABC-2"]), "dumped ABC-2")
+}
+
+func TestSiteCache(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig()
+	h.writeAuth(time.Now().Add(time.Hour))
+	count := func(suffix string) int {
+		var n int
+		for _, r := range h.server.Requests() {
+			line, _, _ := strings.Cut(r, "\n")
+			if strings.HasSuffix(line, suffix) {
+				n++
+			}
+		}
+		return n
+	}
+	for range 2 {
+		_, err := h.run(timesheet, "submit", "--dry-run")
+		assert.NoError(t, err, "run")
+	}
+	assert.Equal(t, 1, count("/_edge/tenant_info"), "tenant info requests")
+	assert.Equal(t, 1, count("/myself"), "myself requests")
+	// the cached cloud ID is removed when the gateway no longer knows it
+	h.server.AddSite(siteHost, "66666666-7777-8888-9999-000000000000", h.fake)
+	_, err := h.run(timesheet, "submit", "--dry-run")
+	assert.Error(t, err, "run with stale cache")
+	_, err = h.run(timesheet, "submit", "--dry-run")
+	assert.NoError(t, err, "run after invalidation")
+	assert.Equal(t, 2, count("/_edge/tenant_info"), "tenant info requests")
+}
+
+func TestSiteCacheOAuth2User(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig()
+	h.writeAuth(time.Now().Add(time.Hour))
+	_, err := h.run(timesheet, "submit", "--dry-run")
+	assert.NoError(t, err, "run")
+	// a grant to another user of the same app doesn't share the cache entry
+	access, _ := h.server.Tokens()
+	assert.NoError(t, config.WriteAuth(&config.OAuth2{
+		ClientID: h.server.ClientID,
+		Secret:   h.server.Secret,
+		Token: &oauth2.Token{
+			AccessToken:  access,
+			TokenType:    "Bearer",
+			RefreshToken: "another-user",
+			Expiry:       time.Now().Add(time.Hour),
+		},
+	}), "write auth")
+	_, err = h.run(timesheet, "submit", "--dry-run")
+	assert.NoError(t, err, "run as another user")
+	var myself int
+	for _, r := range h.server.Requests() {
+		if strings.HasSuffix(r, "/myself") {
+			myself++
+		}
+	}
+	assert.Equal(t, 2, myself, "myself requests")
+	// auth.yml without OAuth2 settings is reported rather than cached
+	h.writeConfigFile("auth.yml", "{}\n")
+	_, err = h.run(timesheet, "submit", "--dry-run")
+	assert.Error(t, err, "run without OAuth2 settings")
+}
+
+func TestDumpWorklogs(t *testing.T) {
+	var testCases = map[string]struct {
+		setup func(*harness)
+	}{
+		"basic-auth": {
+			setup: func(h *harness) { h.writeBasicAuth(false) },
+		},
+		"oauth2": {
+			setup: func(h *harness) { h.writeAuth(time.Now().Add(time.Hour)) },
+		},
+		"datacenter-pat": {
+			setup: func(h *harness) {
+				h.writeDataCenterConfig()
+				h.writePAT()
+			},
+		},
+		"hidden-email": {
+			setup: func(h *harness) {
+				h.writeBasicAuth(false)
+				// profile visibility settings may hide the email address
+				h.fake.CurrentUser.EmailAddress = ""
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfig()
+			tc.setup(h)
+			day := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+			h.addWorklog("ABC-1", day, "one")
+			h.addWorklog("ABC-1", day.AddDate(0, 0, -7), "too old")
+			h.addWorklog("XYZ-3", day.Add(time.Hour), "two")
+			stdout, err := h.run("", "dump-worklogs",
+				"--since=2024-01-01T00:00:00Z")
+			assert.NoError(tt, err, "run")
+			h.assertGolden(filepath.Join("dump-worklogs", name+".stdout"), stdout)
+			h.assertRequests(filepath.Join("dump-worklogs", name+".requests"))
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var testCases = map[string]struct {
+		globals Globals
+		expect  string
+	}{
+		"text": {
+			globals: Globals{LogLevel: "info", LogFormat: "text"},
+			expect:  "level=INFO msg=info issue=ABC-1\n",
+		},
+		"json debug": {
+			globals: Globals{LogLevel: "debug", LogFormat: "json"},
+			expect: `{"level":"DEBUG","msg":"debug","issue":"ABC-1"}` + "\n" +
+				`{"level":"INFO","msg":"info","issue":"ABC-1"}` + "\n",
+		},
+		"warn": {
+			globals: Globals{LogLevel: "warn", LogFormat: "text"},
+		},
+	}
+	// strip timestamps
+	timestamp := regexp.MustCompile(`time=\S+ |"time":"[^"]+",`)
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			var buf strings.Builder
+			log := tc.globals.newLogger(&buf)
+			log.Debug("debug", "issue", "ABC-1")
+			log.Info("info", "issue", "ABC-1")
+			assert.Equal(tt, tc.expect, timestamp.ReplaceAllString(buf.String(), ""))
+		})
+	}
+}
+
+// TestTraceReplay checks that a traced session is redacted, and can be
+// replayed without connecting to Jira.
+func TestTraceReplay(t *testing.T) {
+	const otherHost = "other.atlassian.net"
+	dumpWorklogs := []string{"dump-worklogs", "--since=2024-01-01T00:00:00Z"}
+	var testCases = map[string]struct {
+		setup       func(*harness)
+		stdin       string
+		args        []string
+		expectTrace []string
+	}{
+		"basic-auth-scoped": {
+			setup:       func(h *harness) { h.writeBasicAuth(true) },
+			args:        dumpWorklogs,
+			expectTrace: []string{"https://api.atlassian.com/"},
+		},
+		"oauth2": {
+			setup:       func(h *harness) { h.writeAuth(time.Now().Add(time.Hour)) },
+			args:        dumpWorklogs,
+			expectTrace: []string{"https://api.atlassian.com/"},
+		},
+		// token refreshes and retried requests are recorded
+		"oauth2-refresh-retry": {
+			setup: func(h *harness) {
+				h.writeAuth(time.Now().Add(-time.Hour))
+				h.server.RateLimit = 1
+			},
+			args: dumpWorklogs,
+			expectTrace: []string{
+				"https://" + jiratest.AuthHost + "/oauth/token",
+				`"status": 429`,
+			},
+		},
+		// the requests made to every site are recorded in the same trace
+		"routed": {
+			setup: func(h *harness) {
+				h.writeConfigFile("config.yml", `jiraURL: https://`+siteHost+`/
+routes:
+- profile: other
+  projects:
+  - ^OPS$
+issues:
+- id: ABC-1
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+`)
+				h.writeBasicAuth(false)
+				other := jiratest.New()
+				other.AddIssue("OPS-7", "patching", "indeterminate")
+				h.server.AddSite(otherHost, "other-cloud-id", other)
+				h.writeConfigFile("profiles/other/config.yml",
+					"jiraURL: https://"+otherHost+"/\n")
+				h.writeConfigFile("profiles/other/basicauth.yml",
+					"user: "+h.server.User+"\napiKey: "+h.server.APIKey+"\n")
+			},
+			stdin: timesheet + "1300-1330\nOPS-7 - patching\n",
+			args:  []string{"submit", "--dry-run"},
+			expectTrace: []string{
+				"https://" + siteHost + "/",
+				"https://" + otherHost + "/",
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfig()
+			tc.setup(h)
+			h.addWorklog("ABC-1", time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), "one")
+			tracePath := filepath.Join(h.dir, "trace.har")
+			recorded, err := h.run(tc.stdin,
+				append([]string{"--trace=" + tracePath}, tc.args...)...)
+			assert.NoError(tt, err, "record")
+			data, err := os.ReadFile(tracePath)
+			assert.NoError(tt, err, "read trace")
+			access, refresh := h.server.Tokens()
+			for _, secret := range []string{h.server.APIKey, access, refresh} {
+				assert.NotContains(tt, string(data), secret, "trace is redacted")
+			}
+			for _, expect := range tc.expectTrace {
+				assert.Contains(tt, string(data), expect, "trace")
+			}
+			// credentials aren't required to replay the trace
+			h.removeCredentials()
+			requests := len(h.server.Requests())
+			replayed, err := h.run(tc.stdin,
+				append([]string{"--replay=" + tracePath}, tc.args...)...)
+			assert.NoError(tt, err, "replay")
+			assert.Equal(tt, recorded, replayed, "replayed output")
+			assert.Equal(tt, requests, len(h.server.Requests()), "replay requests")
+		})
+	}
+}
+
+func TestUpdateIssueKeys(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig()
+	h.writeBasicAuth(false)
+	h.fake.MoveIssue("ABC-1", "DEF-1")
+	stdout, err := h.run("", "update-issue-keys")
+	assert.NoError(t, err, "run")
+	h.assertGolden(filepath.Join("update-issue-keys", "moved.stdout"), stdout)
+	h.assertRequests(filepath.Join("update-issue-keys", "moved.requests"))
+	conf, err := config.Read()
+	assert.NoError(t, err, "read config")
+	assert.Equal(t, "DEF-1", conf.Issues[0].ID, "issue ID")
+	assert.Equal(t, "^admin( .+)?$", conf.Issues[0].Regexes[0].String(), "regex")
+}
+
+// TestCloudIDJiraURL checks that the cloud ID is used to construct the API
+// URL.
+func TestCloudIDJiraURL(t *testing.T) {
+	h := newHarness(t)
+	c := &http.Client{Transport: http.DefaultTransport}
+	u, err := client.CloudIDJiraURL(c, "https://"+siteHost+"/")
+	assert.NoError(t, err, "CloudIDJiraURL")
+	assert.Equal(t, "https://api.atlassian.com/ex/jira/"+cloudID, u, "URL")
+	h.assertRequests(filepath.Join("cloud-id", "tenant-info.requests"))
+}
+
+// freePort returns a TCP port on the loopback interface which is not in use.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestAuthorize(t *testing.T) {
+	var testCases = map[string]struct {
+		redirectHost string
+		allow        bool
+		expectErr    string
+	}{
+		"allow": {
+			redirectHost: "127.0.0.1",
+			allow:        true,
+		},
+		"allow localhost": {
+			redirectHost: "localhost",
+			allow:        true,
+		},
+		"deny": {
+			redirectHost: "127.0.0.1",
+			expectErr:    "access_denied",
+		},
+		"public redirect URL": {
+			redirectHost: "example.com",
+			expectErr:    "not a loopback address",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			redirectURL := fmt.Sprintf("http://%s:%d/oauth/redirect",
+				tc.redirectHost, freePort(tt))
+			h.assertNoError(config.WriteAuth(&config.OAuth2{
+				ClientID:    h.server.ClientID,
+				Secret:      h.server.Secret,
+				RedirectURL: redirectURL,
+			}), "write auth")
+			// simulate the browser, which doesn't use the fake Jira transport
+			browser := &http.Client{Transport: &http.Transport{}}
+			browserErr := make(chan error, 1)
+			openURL0 := openURL
+			openURL = func(u string) error {
+				go func() {
+					redirect, err := h.server.Consent(u, tc.allow)
+					if err != nil {
+						browserErr <- err
+						return
+					}
+					resp, err := browser.Get(redirect)
+					if err == nil {
+						resp.Body.Close()
+					}
+					browserErr <- err
+				}()
+				return nil
+			}
+			defer func() { openURL = openURL0 }()
+			_, err := h.run("", "authorize", "--open", "--timeout=10s")
+			if tc.expectErr != "" {
+				assert.Error(tt, err, "run")
+				assert.Contains(tt, err.Error(), tc.expectErr, "error")
+				return
+			}
+			assert.NoError(tt, err, "run")
+			assert.NoError(tt, <-browserErr, "browser")
+			auth, err := config.ReadAuth()
+			assert.NoError(tt, err, "read auth")
+			access, refresh := h.server.Tokens()
+			assert.Equal(tt, access, auth.Token.AccessToken, "access token")
+			assert.Equal(tt, refresh, auth.Token.RefreshToken, "refresh token")
+			assert.Equal(tt, redirectURL, auth.RedirectURL, "redirect URL")
+		})
+	}
+}
+
+func TestParsePastedRedirect(t *testing.T) {
+	const state = "0123abcd"
+	var testCases = map[string]struct {
+		input     string
+		expect    string
+		expectErr string
+	}{
+		"url": {
+			input:  "http://localhost:8080/oauth/redirect?state=" + state + "&code=auth-code\n",
+			expect: "auth-code",
+		},
+		"code": {
+			input:  "  auth-code\n",
+			expect: "auth-code",
+		},
+		"invalid state": {
+			input:     "http://localhost:8080/oauth/redirect?state=other&code=auth-code",
+			expectErr: "invalid state",
+		},
+		"denied": {
+			input:     "http://localhost:8080/oauth/redirect?state=" + state + "&error=access_denied",
+			expectErr: "access_denied",
+		},
+		"empty": {
+			input:     "\n",
+			expectErr: "missing code",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			code, err := parsePastedRedirect(tc.input, state)
+			if tc.expectErr != "" {
+				assert.Error(tt, err, "parsePastedRedirect")
+				assert.Contains(tt, err.Error(), tc.expectErr, "error")
+				return
+			}
+			assert.NoError(tt, err, "parsePastedRedirect")
+			assert.Equal(tt, tc.expect, code, "code")
+		})
+	}
+}
+
+func TestAuthorizeManual(t *testing.T) {
+	h := newHarness(t)
+	// occupy the redirect port, since the redirect server isn't started
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer l.Close()
+	h.assertNoError(config.WriteAuth(&config.OAuth2{
+		ClientID:    h.server.ClientID,
+		Secret:      h.server.Secret,
+		RedirectURL: "http://" + l.Addr().String() + "/oauth/redirect",
+	}), "write auth")
+	// auth runs authorize by default
+	stdout, err := h.run(h.server.Code+"\n", "auth", "--manual")
+	assert.NoError(t, err, "run")
+	assert.Contains(t, stdout, "Paste the URL", "prompt")
+	auth, err := config.ReadAuth()
+	assert.NoError(t, err, "read auth")
+	access, _ := h.server.Tokens()
+	assert.Equal(t, access, auth.Token.AccessToken, "access token")
+}
+
+func TestAuthStatus(t *testing.T) {
+	var testCases = map[string]struct {
+		setup     func(*harness)
+		args      []string
+		expect    []string
+		expectErr bool
+	}{
+		"basic-auth": {
+			setup: func(h *harness) { h.writeBasicAuth(false) },
+			expect: []string{
+				"Mode:         basic auth\n",
+				"Site:         https://" + siteHost + "/\n",
+				"user@example.com",
+			},
+		},
+		"basic-auth-scoped": {
+			setup:  func(h *harness) { h.writeBasicAuth(true) },
+			expect: []string{"Cloud ID:     " + cloudID + "\n"},
+		},
+		"oauth2-refresh": {
+			setup: func(h *harness) {
+				h.writeBasicAuth(false)
+				h.writeAuth(time.Now().Add(-time.Hour))
+			},
+			expect: []string{
+				"Mode:         oauth2\n",
+				"Cloud ID:     " + cloudID + "\n",
+				"Token expiry: ",
+				"Scopes:       offline_access read:jira-work write:jira-work\n",
+			},
+		},
+		"oauth2-basic-auth-flag": {
+			setup: func(h *harness) {
+				h.writeBasicAuth(false)
+				h.writeAuth(time.Now().Add(time.Hour))
+			},
+			args:   []string{"--basic-auth"},
+			expect: []string{"Mode:         basic auth\n"},
+		},
+		"datacenter-pat": {
+			setup: func(h *harness) {
+				h.writeDataCenterConfig()
+				h.writePAT()
+			},
+			expect: []string{
+				"Mode:         personal access token\n",
+				"Site:         https://" + dataCenterHost + "/\n",
+			},
+		},
+		"invalid-credentials": {
+			setup: func(h *harness) {
+				h.writeBasicAuth(false)
+				h.server.APIKey = "revoked"
+			},
+			// the mode is reported even if the credentials don't work
+			expect:    []string{"Mode:         basic auth\n"},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfig()
+			tc.setup(h)
+			stdout, err := h.run("", append([]string{"auth", "status"},
+				tc.args...)...)
+			if tc.expectErr {
+				assert.Error(tt, err, "run")
+			} else {
+				assert.NoError(tt, err, "run")
+				assert.Contains(tt, stdout, "("+h.fake.CurrentUser.AccountID+")",
+					"account")
+			}
+			for _, expect := range tc.expect {
+				assert.Contains(tt, stdout, expect, "stdout")
+			}
+		})
+	}
+}
+
+func TestAuthLogout(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig()
+	h.writeBasicAuth(false)
+	h.writeAuth(time.Now().Add(time.Hour))
+	stdout, err := h.run("", "auth", "logout")
+	assert.NoError(t, err, "run")
+	// the token can't be revoked, so the user is told how to do it
+	assert.Contains(t, stdout, "was not revoked", "stdout")
+	assert.Contains(t, stdout, "https://id.atlassian.com/manage-profile/apps",
+		"stdout")
+	assert.Zero(t, h.server.Requests(), "requests")
+	// the token is removed, but the client credentials are kept
+	auth, err := config.ReadAuth()
+	assert.NoError(t, err, "read auth")
+	assert.Equal(t, h.server.ClientID, auth.ClientID, "client ID")
+	assert.Zero(t, auth.Token, "token")
+	assert.False(t, config.HasBasicAuth(), "basic auth removed")
+	// further commands fail until authorized again
+	_, err = h.run(timesheet, "submit")
+	assert.Error(t, err, "submit")
+}
+
+func TestProfiles(t *testing.T) {
+	const otherHost = "other.atlassian.net"
+	var testCases = map[string]struct {
+		args []string
+		env  string
+	}{
+		"flag": {args: []string{"--profile=other"}},
+		"env":  {env: "other"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfig()
+			h.writeBasicAuth(false)
+			other := jiratest.New()
+			other.AddIssue("ABC-1", "other admin", "indeterminate")
+			other.AddIssue("ABC-2", "other on-call", "new")
+			h.server.AddSite(otherHost, "other-cloud-id", other)
+			h.writeConfigFile("profiles/other/config.yml",
+				"jiraURL: https://"+otherHost+"/\n"+
+					"issues:\n- id: ABC-1\n  regexes:\n  - ^admin( .+)?$\n"+
+					"ignore:\n- ^lunch$\n")
+			h.writeConfigFile("profiles/other/basicauth.yml",
+				"user: "+h.server.User+"\napiKey: "+h.server.APIKey+"\n")
+			tt.Setenv("JIRATIME_PROFILE", tc.env)
+			_, err := h.run(timesheet, append(tc.args, "submit")...)
+			assert.NoError(tt, err, "run")
+			assert.Equal(tt, 2, len(other.Worklogs("ABC-1")), "other ABC-1")
+			assert.Equal(tt, 0, len(h.fake.Worklogs("ABC-1")), "default ABC-1")
+		})
+	}
+}
+
+func TestSubmitRoutes(t *testing.T) {
+	const otherHost = "other.atlassian.net"
+	const routedTimesheet = "1300-1330\nOPS-7 - patching\n"
+	var testCases = map[string]struct {
+		stdin        string
+		homeAuth     bool
+		noPermission []string
+		expectErr    bool
+		expect       map[string]int
+		expectOther  int
+	}{
+		"both sites": {
+			stdin:       timesheet + routedTimesheet,
+			homeAuth:    true,
+			expect:      map[string]int{"ABC-1": 2, "ABC-2": 1},
+			expectOther: 1,
+		},
+		// the home site isn't connected to, so needs no credentials
+		"routed only": {
+			stdin:       routedTimesheet,
+			expectOther: 1,
+		},
+		"no permission on routed site": {
+			stdin:        timesheet + routedTimesheet,
+			homeAuth:     true,
+			noPermission: []string{"OPS"},
+			expectErr:    true,
+			expect:       map[string]int{"ABC-1": 0, "ABC-2": 0},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			h := newHarness(tt)
+			h.writeConfigFile("config.yml", `jiraURL: https://`+siteHost+`/
+routes:
+- profile: other
+  projects:
+  - ^OPS$
+issues:
+- id: ABC-1
+  defaultComment: email / slack
+  regexes:
+  - ^admin( .+)?$
+ignore:
+- ^lunch$
+`)
+			if tc.homeAuth {
+				h.writeBasicAuth(false)
+			}
+			other := jiratest.New()
+			other.AddIssue("OPS-7", "patching", "indeterminate")
+			other.NoPermission = tc.noPermission
+			h.server.AddSite(otherHost, "other-cloud-id", other)
+			h.writeConfigFile("profiles/other/config.yml",
+				"jiraURL: https://"+otherHost+"/\n")
+			h.writeConfigFile("profiles/other/basicauth.yml",
+				"user: "+h.server.User+"\napiKey: "+h.server.APIKey+"\n")
+			_, err := h.run(tc.stdin, "submit")
+			if tc.expectErr {
+				assert.Error(tt, err, "run")
+			} else {
+				assert.NoError(tt, err, "run")
+			}
+			for key, count := range tc.expect {
+				assert.Equal(tt, count, len(h.fake.Worklogs(key)), key)
+			}
+			assert.Equal(tt, tc.expectOther, len(other.Worklogs("OPS-7")), "OPS-7")
+		})
+	}
+}
+
+func TestAuthorizeProfile(t *testing.T) {
+	h := newHarness(t)
+	// occupy the redirect port, since the redirect server isn't started
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer l.Close()
+	h.writeConfigFile("profiles/other/auth.yml", "oauth2:\n"+
+		"  clientID: "+h.server.ClientID+"\n"+
+		"  secret: "+h.server.Secret+"\n"+
+		"  redirectURL: http://"+l.Addr().String()+"/oauth/redirect\n")
+	_, err = h.run(h.server.Code+"\n", "--profile=other", "auth", "--manual")
+	assert.NoError(t, err, "run")
+	// the token is stored in the profile
+	auth, err := config.ReadAuth()
+	assert.NoError(t, err, "read profile auth")
+	access, _ := h.server.Tokens()
+	assert.Equal(t, access, auth.Token.AccessToken, "access token")
+	assert.NoError(t, config.SetProfile(""), "set default profile")
+	assert.False(t, config.HasAuth(), "default auth")
+}
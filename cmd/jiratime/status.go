@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
+)
+
+// AuthStatusCmd represents the `auth status` command.
+type AuthStatusCmd struct {
+	BasicAuth bool          `kong:"help='report on basic auth instead of OAuth2'"`
+	Timeout   time.Duration `kong:"default=30s,help='maximum duration allowed for the command to return'"`
+}
+
+// formatUser returns a description of the given Jira user.
+func formatUser(u *jira.User) string {
+	var parts []string
+	if u.DisplayName != "" {
+		parts = append(parts, u.DisplayName)
+	}
+	if u.EmailAddress != "" {
+		parts = append(parts, "<"+u.EmailAddress+">")
+	}
+	parts = append(parts, "("+u.AccountID+")")
+	return strings.Join(parts, " ")
+}
+
+// Run the AuthStatus command. The authentication mode and site are printed
+// before connecting to Jira, so that they are reported even if the
+// credentials don't work.
+func (cmd *AuthStatusCmd) Run(g *Globals, log *slog.Logger) error {
+	ctx, cancel := getContext(log, cmd.Timeout)
+	defer cancel()
+	conf, err := config.Read()
+	if err != nil {
+		return fmt.Errorf("couldn't load config: %v", err)
+	}
+	report := func(key, value string) {
+		fmt.Printf("%-14s%s\n", key+":", value)
+	}
+	store := conf.CredentialStore()
+	mode := authMode(conf, store, cmd.BasicAuth)
+	report("Profile", config.Profile())
+	report("Mode", mode)
+	report("Site", conf.JiraURL)
+	j, cloudID, err := newJiraClient(ctx, log, g, conf, store, mode, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't get Jira client: %v", err)
+	}
+	if cloudID != "" {
+		report("Cloud ID", cloudID)
+	}
+	user, err := j.Myself(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't get current user: %v", err)
+	}
+	report("Account", formatUser(user))
+	if mode != authModeOAuth2 {
+		return nil
+	}
+	// read the token after the request, since it may have been refreshed
+	auth, err := store.ReadOAuth2()
+	if err != nil {
+		return fmt.Errorf("couldn't read OAuth2 token: %v", err)
+	}
+	report("Token expiry", auth.Token.Expiry.Local().Format(time.RFC3339))
+	scope := auth.Scope
+	if scope == "" {
+		scope = "unknown, run `jiratime auth` to update"
+	}
+	report("Scopes", scope)
+	return nil
+}
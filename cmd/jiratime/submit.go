@@ -1,51 +1,240 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/smlx/jiratime/internal/client"
 	"github.com/smlx/jiratime/internal/config"
 	"github.com/smlx/jiratime/internal/parse"
 	"github.com/smlx/jiratime/internal/process"
+	"github.com/smlx/jiratime/internal/sink"
+	"github.com/smlx/jiratime/internal/tempo"
+	"golang.org/x/exp/slog"
 )
 
 // SubmitCmd represents the default `submit` command.
 type SubmitCmd struct {
-	DayOffset int  `kong:"short='d',help='submit time for a day at some offset to today'"`
-	DryRun    bool `kong:"help='read-only mode; do not actually make any changes in Jira'"`
-	BasicAuth bool `kong:"help='use basic auth instead of OAuth2'"`
+	DayOffset       int           `kong:"short='d',help='submit time for a day at some offset to today'"`
+	DryRun          bool          `kong:"help='read-only mode; validate worklogs without submitting them to any sink'"`
+	BasicAuth       bool          `kong:"help='use basic auth instead of OAuth2'"`
+	Concurrency     int           `kong:"default=4,help='maximum number of worklogs to upload concurrently'"`
+	AllowDuplicates bool          `kong:"help='submit entries even if they match a worklog previously submitted by jiratime'"`
+	Timeout         time.Duration `kong:"default=60s,help='maximum duration allowed for the command to return'"`
+}
+
+// printResults prints the per-entry upload results to w.
+func printResults(w io.Writer, results []client.UploadResult) {
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case result.Err != nil:
+			status = result.Err.Error()
+		case result.RolledBack:
+			status = "rolled back"
+		case result.Duplicate:
+			status = "duplicate"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Issue,
+			result.Worklog.Started.Format("1504"), result.Worklog.Duration,
+			result.Metadata.Summary, status)
+	}
+}
+
+// getTempoWriter constructs a WorklogWriter which submits worklogs to the
+// Tempo instance of conf as the Jira user with the given account ID.
+func getTempoWriter(
+	log *slog.Logger,
+	accountID string,
+	conf *config.Config,
+	issues []config.Issue,
+) (client.WorklogWriter, error) {
+	tempoConf, err := conf.ReadTempo()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read tempo config: %v", err)
+	}
+	baseURL := tempoConf.URL
+	if baseURL == "" {
+		baseURL = tempo.DefaultURL
+	}
+	httpClient, err := client.NewTokenHTTPClient(log, conf.Network, tempoConf.Token,
+		nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct Tempo HTTP client: %v", err)
+	}
+	tc, err := tempo.NewClient(httpClient, baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct Tempo client: %v", err)
+	}
+	return tempo.NewWriter(tc, accountID, issues), nil
+}
+
+// jiraSite is the Jira site of a profile, which is connected to when the
+// first sink using it is constructed.
+type jiraSite struct {
+	conf      *config.Config
+	j         client.Jira
+	accountID string
+}
+
+// jiraSink constructs a sink of the given backend type which submits
+// worklogs to the given site. issues is the list of known issues used by the
+// Tempo backend.
+func (cmd *SubmitCmd) jiraSink(
+	ctx context.Context,
+	log *slog.Logger,
+	g *Globals,
+	site *jiraSite,
+	name string,
+	backend string,
+	issues []config.Issue,
+	timesheetID string,
+) (sink.Sink, error) {
+	var err error
+	if site.j == nil {
+		site.j, site.accountID, err = getJiraClient(ctx, log, g, site.conf,
+			cmd.BasicAuth)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get Jira client: %v", err)
+		}
+	}
+	opts := client.UploadOptions{
+		DayOffset:        cmd.DayOffset,
+		Concurrency:      cmd.Concurrency,
+		StatusCategories: site.conf.StatusCategories,
+		Version:          version,
+		TimesheetID:      timesheetID,
+		AllowDuplicates:  cmd.AllowDuplicates,
+		AccountID:        site.accountID,
+	}
+	if backend == config.BackendTempo {
+		opts.Writer, err = getTempoWriter(log, site.accountID, site.conf, issues)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get Tempo writer: %v", err)
+		}
+	}
+	return sink.NewJira(name, log, site.j, opts), nil
+}
+
+// timesheetID returns an identifier for the given timesheet submitted for
+// the day at the given offset to today.
+func timesheetID(input []byte, dayOffset int) string {
+	day := time.Now().AddDate(0, 0, dayOffset).Format("2006-01-02")
+	sum := sha256.Sum256(append([]byte(day+"\n"), input...))
+	return hex.EncodeToString(sum[:8])
 }
 
 // Run the Submit command.
-func (cmd *SubmitCmd) Run() error {
-	// global timeout of 60 seconds
-	ctx, cancel := getContext(60 * time.Second)
+func (cmd *SubmitCmd) Run(g *Globals, log *slog.Logger) error {
+	ctx, cancel := getContext(log, cmd.Timeout)
 	defer cancel()
 	// read config file
 	conf, err := config.Read()
 	if err != nil {
 		return fmt.Errorf("couldn't load config: %v", err)
 	}
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("couldn't read timesheet: %v", err)
+	}
 	// parse each line of input, generating a map of jira tickets
 	// with associated Worklog entries
-	worklogs, err := parse.Input(os.Stdin, conf)
+	worklogs, err := parse.Input(log, bytes.NewReader(input), conf)
 	if err != nil {
 		return fmt.Errorf("couldn't parse worklogs: %v", err)
 	}
 	// process the worklogs to meet organisational policy
-	process.RoundWorklogs(worklogs, conf.RoundIssues)
+	process.RoundWorklogs(log, worklogs, conf.RoundIssues)
+	process.RestrictVisibility(log, worklogs, conf.Visibility)
 
-	c, _, persistToken, err := getJiraClient(ctx, conf.JiraURL, cmd.BasicAuth)
-	if err != nil {
-		return fmt.Errorf("couldn't get Jira client: %v", err)
+	// split the worklogs by the profile of the Jira site they are routed to
+	useHome := len(conf.Routes) == 0
+	var profiles []string
+	for issue := range worklogs {
+		if p := conf.Route(issue); p == "" {
+			useHome = true
+		} else if !slices.Contains(profiles, p) {
+			profiles = append(profiles, p)
+		}
+	}
+	slices.Sort(profiles)
+	// route restricts a sink to the worklogs routed to the given profile
+	route := func(s sink.Sink, profile string) sink.Sink {
+		if len(conf.Routes) == 0 {
+			return s
+		}
+		return sink.NewFilter(s, func(issue string) bool {
+			return conf.Route(issue) == profile
+		})
 	}
 
-	// push the worklogs into jira
-	err = client.UploadWorklogs(ctx, c, worklogs, cmd.DayOffset, cmd.DryRun)
-	if err != nil {
-		return fmt.Errorf("couldn't upload worklogs: %v", err)
+	// construct the sinks, connecting to each Jira site only if required
+	id := timesheetID(input, cmd.DayOffset)
+	home := &jiraSite{conf: conf}
+	var sinks []sink.Sink
+	for _, sc := range conf.SubmitSinks() {
+		switch sc.Type {
+		case config.BackendJira, config.BackendTempo:
+			if !useHome {
+				continue
+			}
+			s, err := cmd.jiraSink(ctx, log, g, home, sc.Type, sc.Type,
+				conf.Issues, id)
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, route(s, ""))
+		case config.SinkLedger:
+			sinks = append(sinks, sink.NewLedger(sc.Path, sc.Format, cmd.DayOffset))
+		case config.SinkStdout:
+			sinks = append(sinks, sink.NewStdout(os.Stdout, sc.Format, cmd.DayOffset))
+		}
+	}
+	// the Jira and Tempo sinks of the sites of other profiles are validated
+	// along with the rest, so that nothing is submitted to any site unless
+	// the whole timesheet is valid.
+	for _, p := range profiles {
+		siteConf, err := config.ReadProfile(p)
+		if err != nil {
+			return fmt.Errorf("couldn't load config of profile %s: %v", p, err)
+		}
+		site := &jiraSite{conf: siteConf}
+		n := len(sinks)
+		for _, sc := range siteConf.SubmitSinks() {
+			if sc.Type != config.BackendJira && sc.Type != config.BackendTempo {
+				continue
+			}
+			s, err := cmd.jiraSink(ctx, log, g, site, p+"/"+sc.Type, sc.Type,
+				conf.Issues, id)
+			if err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			sinks = append(sinks, route(s, p))
+		}
+		if len(sinks) == n {
+			return fmt.Errorf("profile %s has no jira or tempo sink", p)
+		}
+	}
+
+	// submit the worklogs to all sinks
+	if err = sink.Submit(ctx, log, sinks, worklogs, cmd.DryRun); err != nil {
+		// some worklogs may have been uploaded, so tell the user which
+		for _, s := range sinks {
+			if f, ok := s.(*sink.Filter); ok {
+				s = f.Unwrap()
+			}
+			if js, ok := s.(*sink.Jira); ok {
+				printResults(os.Stderr, js.Results())
+			}
+		}
+		return fmt.Errorf("couldn't submit worklogs: %v", err)
 	}
-	return persistToken()
+	return nil
 }
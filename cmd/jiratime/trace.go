@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/trace"
+)
+
+// transportWrapper returns a client.TransportWrapper which records or
+// replays requests, as requested by the global flags. It returns nil if
+// requests are not traced.
+func (g *Globals) transportWrapper() (client.TransportWrapper, error) {
+	switch {
+	case g.Replay != "":
+		if g.replayer == nil {
+			f, err := os.Open(g.Replay)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't open trace: %v", err)
+			}
+			defer f.Close()
+			g.replayer, err = trace.NewReplayer(f)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read trace: %v", err)
+			}
+		}
+		return func(http.RoundTripper) http.RoundTripper {
+			return g.replayer
+		}, nil
+	case g.Trace != "":
+		if g.recorder == nil {
+			g.recorder = trace.NewRecorder("jiratime", version)
+		}
+		return g.recorder.Wrap, nil
+	}
+	return nil, nil
+}
+
+// replayedGateway returns true if the replayed trace shows that the Jira
+// Cloud site at the given URL was accessed via the API gateway, which is the
+// case for OAuth2 and scoped API tokens.
+func (g *Globals) replayedGateway(jiraURL string) (bool, error) {
+	u, err := client.TenantInfoURL(jiraURL)
+	if err != nil {
+		return false, err
+	}
+	return g.replayer.Recorded(http.MethodGet, u), nil
+}
+
+// AfterRun writes the recorded trace, if any. It is called by kong after the
+// command has run, even if the command failed.
+func (g *Globals) AfterRun() error {
+	if g.recorder == nil {
+		return nil
+	}
+	f, err := os.OpenFile(g.Trace, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't create trace file: %v", err)
+	}
+	if err = g.recorder.Write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't write trace file: %v", err)
+	}
+	return f.Close()
+}
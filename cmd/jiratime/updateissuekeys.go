@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
+)
+
+// UpdateIssueKeysCmd represents the `update-issue-keys` command.
+type UpdateIssueKeysCmd struct {
+	DryRun    bool `kong:"help='print the updated issue keys without rewriting the config file'"`
+	BasicAuth bool `kong:"help='use basic auth instead of OAuth2'"`
+}
+
+// Run the UpdateIssueKeys command.
+func (cmd *UpdateIssueKeysCmd) Run(g *Globals, log *slog.Logger) error {
+	ctx, cancel := getContext(log, 60*time.Second)
+	defer cancel()
+	// read config file
+	conf, err := config.Read()
+	if err != nil {
+		return fmt.Errorf("couldn't load config: %v", err)
+	}
+	c, _, err := getJiraClient(ctx, log, g, conf, cmd.BasicAuth)
+	if err != nil {
+		return fmt.Errorf("couldn't get Jira client: %v", err)
+	}
+	keys := make([]string, len(conf.Issues))
+	for i, issue := range conf.Issues {
+		keys[i] = issue.ID
+	}
+	canonical, err := client.CanonicalIssueKeys(ctx, log, c, keys)
+	if err != nil {
+		return fmt.Errorf("couldn't look up issue keys: %v", err)
+	}
+	// update the issue IDs in config
+	for i, issue := range conf.Issues {
+		if key, ok := canonical[issue.ID]; ok {
+			fmt.Printf("%s -> %s\n", issue.ID, key)
+			conf.Issues[i].ID = key
+		}
+	}
+	if cmd.DryRun || len(canonical) == 0 {
+		return nil
+	}
+	if err = config.Write(conf); err != nil {
+		return fmt.Errorf("couldn't write config: %v", err)
+	}
+	return nil
+}
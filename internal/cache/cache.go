@@ -0,0 +1,156 @@
+// Package cache implements the cache of information about Jira sites which
+// would otherwise be requested on every run.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+const dirSuffix = "jiratime/sites"
+
+// TTL is the maximum age of a cache entry.
+const TTL = 24 * time.Hour
+
+// Site is the cached information about a Jira site, as seen by the user of
+// a particular set of credentials.
+type Site struct {
+	// CloudID is the cloud ID of a Jira Cloud site.
+	CloudID string `json:"cloudID,omitempty"`
+	// AccountID is the account ID of the authenticated user.
+	AccountID string `json:"accountID,omitempty"`
+	// Updated is the time the entry was written.
+	Updated time.Time `json:"updated"`
+}
+
+// Key returns the cache key of the given Jira URL and credential identity.
+// The identity is hashed, so it may contain secrets.
+func Key(jiraURL, identity string) string {
+	sum := sha256.Sum256([]byte(jiraURL + "\n" + identity))
+	return hex.EncodeToString(sum[:16])
+}
+
+// path returns the path of the cache file of the given key.
+func path(key string) (string, error) {
+	path, err := xdg.CacheFile(dirSuffix + "/" + key + ".json")
+	if err != nil {
+		return "", fmt.Errorf("couldn't get path to cache file: %v", err)
+	}
+	return path, nil
+}
+
+// Read returns the entry with the given key. It returns an empty entry if
+// there is none, or if it is older than TTL.
+func Read(key string) (*Site, error) {
+	path, err := path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Site{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read cache file: %v", err)
+	}
+	var s Site
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal cache entry: %v", err)
+	}
+	if time.Since(s.Updated) > TTL {
+		return &Site{}, nil
+	}
+	return &s, nil
+}
+
+// Write the given entry with the given key. The file is replaced atomically
+// so that concurrent runs never read a partial entry.
+func Write(key string, s *Site) error {
+	path, err := path(key)
+	if err != nil {
+		return err
+	}
+	entry := *s
+	entry.Updated = time.Now()
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal cache entry: %v", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("couldn't create temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't write temporary file: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("couldn't close temporary file: %v", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("couldn't replace cache file: %v", err)
+	}
+	return nil
+}
+
+// Remove the entry with the given key, if it exists.
+func Remove(key string) error {
+	path, err := path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("couldn't remove cache file: %v", err)
+	}
+	return nil
+}
+
+// Clear removes all entries.
+func Clear() error {
+	err := os.RemoveAll(filepath.Join(xdg.CacheHome, filepath.FromSlash(dirSuffix)))
+	if err != nil {
+		return fmt.Errorf("couldn't remove cache: %v", err)
+	}
+	return nil
+}
+
+// invalidatingTransport is an http.RoundTripper which removes a cache entry
+// if a response shows that the entry may be stale.
+type invalidatingTransport struct {
+	key  string
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *invalidatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err == nil && (resp.StatusCode == http.StatusUnauthorized ||
+		resp.StatusCode == http.StatusNotFound) {
+		_ = Remove(t.key)
+	}
+	return resp, err
+}
+
+// Invalidate wraps the transport of the given HTTP client so that the entry
+// with the given key is removed if a response has status 401 or 404, which
+// is the case if the cached cloud ID or account no longer exists. Jira also
+// returns 404 for missing issues, after which the entry is simply requested
+// again on the next run.
+func Invalidate(c *http.Client, key string) {
+	c.Transport = &invalidatingTransport{key: key, next: c.Transport}
+}
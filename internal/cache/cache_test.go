@@ -0,0 +1,77 @@
+package cache_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/alecthomas/assert/v2"
+	"github.com/smlx/jiratime/internal/cache"
+)
+
+// setCacheHome sets XDG_CACHE_HOME to a temporary directory.
+func setCacheHome(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	xdg.Reload()
+	return dir
+}
+
+func TestRead(t *testing.T) {
+	var testCases = map[string]struct {
+		updated time.Time
+		expect  string
+	}{
+		"fresh":   {updated: time.Now().Add(-time.Hour), expect: "account"},
+		"expired": {updated: time.Now().Add(-cache.TTL - time.Hour)},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			dir := setCacheHome(tt)
+			key := cache.Key("https://example.atlassian.net/", "user")
+			path := filepath.Join(dir, "jiratime", "sites", key+".json")
+			assert.NoError(tt, os.MkdirAll(filepath.Dir(path), 0700), "mkdir")
+			assert.NoError(tt, os.WriteFile(path, []byte(`{"accountID":"account",`+
+				`"updated":"`+tc.updated.Format(time.RFC3339)+`"}`), 0600), "write")
+			site, err := cache.Read(key)
+			assert.NoError(tt, err, "read")
+			assert.Equal(tt, tc.expect, site.AccountID, "account ID")
+		})
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	var testCases = map[string]struct {
+		status int
+		expect string
+	}{
+		"ok":           {status: http.StatusOK, expect: "account"},
+		"unauthorized": {status: http.StatusUnauthorized},
+		"not found":    {status: http.StatusNotFound},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			setCacheHome(tt)
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(tc.status)
+				}))
+			defer srv.Close()
+			key := cache.Key(srv.URL, "user")
+			assert.NoError(tt, cache.Write(key, &cache.Site{AccountID: "account"}),
+				"write")
+			c := srv.Client()
+			cache.Invalidate(c, key)
+			resp, err := c.Get(srv.URL)
+			assert.NoError(tt, err, "get")
+			resp.Body.Close()
+			site, err := cache.Read(key)
+			assert.NoError(tt, err, "read")
+			assert.Equal(tt, tc.expect, site.AccountID, "account ID")
+		})
+	}
+}
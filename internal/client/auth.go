@@ -6,9 +6,16 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// DefaultRedirectURL is the OAuth2 redirect URL used if none is configured.
+const DefaultRedirectURL = "http://localhost:8080/oauth/redirect"
+
 // GetOAuth2Config gets an OAuth2 Config object configured for Atlassian Jira
 // Cloud.
 func GetOAuth2Config(auth *config.OAuth2) *oauth2.Config {
+	redirectURL := auth.RedirectURL
+	if redirectURL == "" {
+		redirectURL = DefaultRedirectURL
+	}
 	return &oauth2.Config{
 		ClientID:     auth.ClientID,
 		ClientSecret: auth.Secret,
@@ -22,6 +29,6 @@ func GetOAuth2Config(auth *config.OAuth2) *oauth2.Config {
 			TokenURL: "https://auth.atlassian.com/oauth/token",
 			AuthURL:  "https://auth.atlassian.com/authorize",
 		},
-		RedirectURL: "http://localhost:8080/oauth/redirect",
+		RedirectURL: redirectURL,
 	}
 }
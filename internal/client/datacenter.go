@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+)
+
+// dataCenter implements the Jira interface for Jira Data Center. It differs
+// from Jira Cloud only where the Cloud REST API has diverged from the v2 API
+// still served by Data Center.
+type dataCenter struct {
+	goJira
+}
+
+// NewDataCenterJira returns a Jira implementation for Jira Data Center which
+// uses the given go-jira client.
+func NewDataCenterJira(c *jira.Client) Jira {
+	return &dataCenter{goJira: goJira{c: c}}
+}
+
+// SearchIssues implements the Jira interface. Data Center doesn't support the
+// search/jql endpoint, so this uses the v2 search endpoint with offset
+// pagination.
+func (j *dataCenter) SearchIssues(ctx context.Context, jql string,
+	fields []string) ([]jira.Issue, error) {
+	var issues []jira.Issue
+	for {
+		query := url.Values{}
+		query.Set("jql", jql)
+		query.Set("fields", strings.Join(fields, ","))
+		query.Set("startAt", strconv.Itoa(len(issues)))
+		query.Set("maxResults", "1000")
+		req, err := j.c.NewRequest(ctx, http.MethodGet,
+			"rest/api/2/search?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't construct request: %v", err)
+		}
+		result := struct {
+			Total  int          `json:"total"`
+			Issues []jira.Issue `json:"issues"`
+		}{}
+		if _, err = j.c.Do(req, &result); err != nil {
+			return nil, fmt.Errorf("couldn't search: %v", err)
+		}
+		issues = append(issues, result.Issues...)
+		if len(result.Issues) == 0 || len(issues) >= result.Total {
+			return issues, nil
+		}
+	}
+}
+
+// dataCenterUser is a Jira Data Center user. Data Center users have a key
+// instead of an account ID.
+type dataCenterUser struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	EmailAddress string `json:"emailAddress"`
+	DisplayName  string `json:"displayName"`
+	Active       bool   `json:"active"`
+	TimeZone     string `json:"timeZone"`
+}
+
+// user converts the dataCenterUser to a jira.User, with the user key as the
+// account ID.
+func (u *dataCenterUser) user() *jira.User {
+	if u == nil {
+		return nil
+	}
+	return &jira.User{
+		AccountID:    u.Key,
+		EmailAddress: u.EmailAddress,
+		DisplayName:  u.DisplayName,
+		Active:       u.Active,
+		TimeZone:     u.TimeZone,
+	}
+}
+
+// dataCenterWorklogRecord is a Jira Data Center worklog record.
+type dataCenterWorklogRecord struct {
+	jira.WorklogRecord
+	Author       *dataCenterUser `json:"author,omitempty"`
+	UpdateAuthor *dataCenterUser `json:"updateAuthor,omitempty"`
+}
+
+// GetWorklogs implements the Jira interface. Data Center ignores the
+// startedAfter parameter and returns all worklog records, so they are
+// filtered here. Author user keys are returned as account IDs.
+func (j *dataCenter) GetWorklogs(ctx context.Context, issueKey string,
+	since time.Time) ([]jira.WorklogRecord, error) {
+	req, err := j.c.NewRequest(ctx, http.MethodGet,
+		fmt.Sprintf("rest/api/2/issue/%s/worklog?expand=properties",
+			url.PathEscape(issueKey)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	worklog := struct {
+		Worklogs []dataCenterWorklogRecord `json:"worklogs"`
+	}{}
+	if _, err = j.c.Do(req, &worklog); err != nil {
+		return nil, fmt.Errorf("couldn't get worklogs: %v", err)
+	}
+	var wlrs []jira.WorklogRecord
+	for _, dcwlr := range worklog.Worklogs {
+		wlr := dcwlr.WorklogRecord
+		if wlr.Started == nil || !time.Time(*wlr.Started).After(since) {
+			continue
+		}
+		wlr.Author = dcwlr.Author.user()
+		wlr.UpdateAuthor = dcwlr.UpdateAuthor.user()
+		wlrs = append(wlrs, wlr)
+	}
+	return wlrs, nil
+}
+
+// Myself implements the Jira interface. The user key is returned as the
+// account ID.
+func (j *dataCenter) Myself(ctx context.Context) (*jira.User, error) {
+	req, err := j.c.NewRequest(ctx, http.MethodGet, "rest/api/2/myself", nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	var user dataCenterUser
+	if _, err = j.c.Do(req, &user); err != nil {
+		return nil, fmt.Errorf("couldn't get current user: %v", err)
+	}
+	return user.user(), nil
+}
@@ -9,80 +9,35 @@ import (
 	"golang.org/x/exp/slog"
 )
 
-// getAllIssues returns all the issues by automatically paging through results.
-func getAllIssues(ctx context.Context, c *jira.Client,
-	search string) ([]jira.Issue, error) {
-	var issues []jira.Issue
-	var nextPageToken string
-	for {
-		opt := &jira.SearchOptionsV2{
-			MaxResults:    1000, // max 1000
-			Fields:        []string{"id", "key"},
-			NextPageToken: nextPageToken,
-		}
-		chunk, resp, err := c.Issue.SearchV2JQL(ctx, search, opt)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't search: %v", err)
-		}
-		issues = append(issues, chunk...)
-		// nextPageToken is null on the initial request, and on the last page
-		if len(resp.NextPageToken) == 0 {
-			return issues, nil
-		}
-		nextPageToken = resp.NextPageToken
+// getWorklogRecords returns all worklogs where the author is the user with the
+// given account ID on the given issue.
+func getWorklogRecords(ctx context.Context, j Jira,
+	issueID, accountID string, since time.Time) ([]jira.WorklogRecord, error) {
+	worklogs, err := j.GetWorklogs(ctx, issueID, since)
+	if err != nil {
+		return nil, err
 	}
-}
-
-type worklogOpts struct {
-	jira.SearchOptions
-	StartedAfter int64 `url:"startedAfter,omitempty"`
-}
-
-// getWorklogRecords returns all worklogs where the author is the given user
-// on the given issue.
-func getWorklogRecords(ctx context.Context, c *jira.Client,
-	issueID, authorEmail string, since time.Time) ([]jira.WorklogRecord, error) {
-	last := 0
+	// filter the worklog records by author
 	var wlrs []jira.WorklogRecord
-	for {
-		opt := worklogOpts{
-			SearchOptions: jira.SearchOptions{
-				MaxResults: 5000, // max 5000
-				StartAt:    last,
-			},
-			StartedAfter: since.UnixMilli(),
-		}
-		worklog, resp, err := c.Issue.GetWorklogs(ctx, issueID,
-			jira.WithQueryOptions(&opt))
-		if err != nil {
-			return nil, fmt.Errorf("couldn't search: %v", err)
-		}
-		if resp.StatusCode >= 400 {
-			return nil, fmt.Errorf("bad response %d: %v", resp.StatusCode, resp.Status)
-		}
-		// filter the worklog records by author
-		for _, wlr := range worklog.Worklogs {
-			if wlr.Author.EmailAddress == authorEmail {
-				wlrs = append(wlrs, wlr)
-			}
-		}
-		last = resp.StartAt + len(worklog.Worklogs)
-		// return if we have paged through all records otherwise get the next page
-		// NOTE: the jira library is buggy and returns resp.Total == 0 here, so
-		// check for "last page" indirectly.
-		if len(worklog.Worklogs) < opt.MaxResults {
-			return wlrs, nil
+	for _, wlr := range worklogs {
+		if wlr.Author != nil && wlr.Author.AccountID == accountID {
+			wlrs = append(wlrs, wlr)
 		}
 	}
+	return wlrs, nil
 }
 
-// Worklogs returns the worklogs since the given time.
-func Worklogs(ctx context.Context, log *slog.Logger, c *jira.Client, userEmail string,
+// Worklogs returns the worklogs since the given time authored by the user
+// with the given account ID.
+func Worklogs(ctx context.Context, log *slog.Logger, j Jira, accountID string,
 	since time.Time) (map[string][]jira.WorklogRecord, error) {
+	if accountID == "" {
+		return nil, fmt.Errorf("empty account ID")
+	}
 	// get all the issues with a worklog by the author
-	issues, err := getAllIssues(ctx, c,
+	issues, err := j.SearchIssues(ctx,
 		fmt.Sprintf(`worklogAuthor = currentUser() AND worklogDate >= "%s"`,
-			since.Format("2006-01-02")))
+			since.Format("2006-01-02")), []string{"id", "key"})
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get issues: %v", err)
 	}
@@ -90,7 +45,7 @@ func Worklogs(ctx context.Context, log *slog.Logger, c *jira.Client, userEmail s
 	// iterate through the issues getting all the associated worklogs
 	worklogs := map[string][]jira.WorklogRecord{}
 	for _, issue := range issues {
-		wlrs, err := getWorklogRecords(ctx, c, issue.Key, userEmail, since)
+		wlrs, err := getWorklogRecords(ctx, j, issue.Key, accountID, since)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't get worklogs: %v", err)
 		}
@@ -101,3 +56,18 @@ func Worklogs(ctx context.Context, log *slog.Logger, c *jira.Client, userEmail s
 	}
 	return worklogs, nil
 }
+
+// JiratimeWorklogs filters the given worklogs to those created by jiratime.
+// Issues without any such worklogs are omitted.
+func JiratimeWorklogs(
+	worklogs map[string][]jira.WorklogRecord) map[string][]jira.WorklogRecord {
+	filtered := map[string][]jira.WorklogRecord{}
+	for key, wlrs := range worklogs {
+		for i := range wlrs {
+			if GetWorklogProperty(&wlrs[i]) != nil {
+				filtered[key] = append(filtered[key], wlrs[i])
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,40 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/client"
+)
+
+func TestWorklogs(t *testing.T) {
+	f := newFake()
+	since := time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)
+	other := jira.User{AccountID: "other", EmailAddress: "other@example.com"}
+	add := func(key string, started time.Time, author *jira.User) {
+		jt := jira.Time(started)
+		_, err := f.AddWorklog(context.Background(), key, &client.WorklogRecord{
+			WorklogRecord: jira.WorklogRecord{
+				Started:          &jt,
+				TimeSpentSeconds: 900,
+				Author:           author,
+			},
+		})
+		assert.NoError(t, err, "AddWorklog")
+	}
+	add("ABC-1", since.Add(9*time.Hour), nil)
+	add("ABC-1", since.Add(10*time.Hour), &other)
+	add("ABC-1", since.Add(-15*time.Hour), nil)
+	add("ABC-2", since.Add(-15*time.Hour), nil)
+	add("XYZ-3", since.Add(24*time.Hour), nil)
+	add("XYZ-3", since.Add(25*time.Hour), nil)
+	worklogs, err := client.Worklogs(context.Background(), discardLogger, f,
+		f.CurrentUser.AccountID, since)
+	assert.NoError(t, err, "Worklogs")
+	assert.Equal(t, 2, len(worklogs), "issues")
+	assert.Equal(t, 1, len(worklogs["ABC-1"]), "ABC-1 worklogs")
+	assert.Equal(t, 2, len(worklogs["XYZ-3"]), "XYZ-3 worklogs")
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+)
+
+// Jira is the subset of the Jira REST API used by jiratime.
+type Jira interface {
+	// SearchIssues returns all the issues matching the given JQL search, with
+	// only the given fields populated.
+	SearchIssues(ctx context.Context, jql string,
+		fields []string) ([]jira.Issue, error)
+	// GetIssue returns the issue with the given key, with only the given fields
+	// populated. If the issue has been moved, the returned issue has the new
+	// key.
+	GetIssue(ctx context.Context, key string, fields []string) (*jira.Issue, error)
+	// GetWorklogs returns all the worklog records on the given issue started
+	// strictly after the given time, including their entity properties.
+	GetWorklogs(ctx context.Context, issueKey string,
+		since time.Time) ([]jira.WorklogRecord, error)
+	// AddWorklog adds the worklog record to the given issue and returns the
+	// created record.
+	AddWorklog(ctx context.Context, issueKey string,
+		wlr *WorklogRecord) (*WorklogRecord, error)
+	// DeleteWorklog deletes the worklog record with the given ID from the given
+	// issue.
+	DeleteWorklog(ctx context.Context, issueKey, worklogID string) error
+	// TimeTrackingEnabled returns true if time tracking is enabled.
+	TimeTrackingEnabled(ctx context.Context) (bool, error)
+	// HavePermission returns true if the current user has the given permission
+	// in the given project.
+	HavePermission(ctx context.Context, projectKey, permission string) (bool, error)
+	// GroupExists returns true if a group with the given name exists.
+	GroupExists(ctx context.Context, name string) (bool, error)
+	// ProjectRoles returns the names of the roles in the given project.
+	ProjectRoles(ctx context.Context, projectKey string) ([]string, error)
+	// Myself returns the authenticated user.
+	Myself(ctx context.Context) (*jira.User, error)
+}
+
+// WorklogVisibility restricts the visibility of a worklog record to the
+// members of a group or project role.
+type WorklogVisibility struct {
+	// Type is either group or role.
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// WorklogRecord is a Jira worklog record including its visibility, which
+// isn't part of the go-jira WorklogRecord.
+type WorklogRecord struct {
+	jira.WorklogRecord
+	Visibility *WorklogVisibility `json:"visibility,omitempty"`
+}
+
+// goJira implements the Jira interface using the go-jira client.
+type goJira struct {
+	c *jira.Client
+}
+
+// NewJira returns a Jira implementation which uses the given go-jira client.
+func NewJira(c *jira.Client) Jira {
+	return &goJira{c: c}
+}
+
+// SearchIssues implements the Jira interface. It automatically pages through
+// results.
+func (j *goJira) SearchIssues(ctx context.Context, jql string,
+	fields []string) ([]jira.Issue, error) {
+	var issues []jira.Issue
+	var nextPageToken string
+	for {
+		opt := &jira.SearchOptionsV2{
+			MaxResults:    1000, // max 1000
+			Fields:        fields,
+			NextPageToken: nextPageToken,
+		}
+		chunk, resp, err := j.c.Issue.SearchV2JQL(ctx, jql, opt)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't search: %v", err)
+		}
+		issues = append(issues, chunk...)
+		// nextPageToken is null on the initial request, and on the last page
+		if len(resp.NextPageToken) == 0 {
+			return issues, nil
+		}
+		nextPageToken = resp.NextPageToken
+	}
+}
+
+// GetIssue implements the Jira interface.
+func (j *goJira) GetIssue(ctx context.Context, key string,
+	fields []string) (*jira.Issue, error) {
+	issue, _, err := j.c.Issue.Get(ctx, key, &jira.GetQueryOptions{
+		Fields: strings.Join(fields, ","),
+	})
+	return issue, err
+}
+
+type worklogOpts struct {
+	jira.SearchOptions
+	StartedAfter int64  `url:"startedAfter,omitempty"`
+	Expand       string `url:"expand,omitempty"`
+}
+
+// GetWorklogs implements the Jira interface. It automatically pages through
+// results.
+func (j *goJira) GetWorklogs(ctx context.Context, issueKey string,
+	since time.Time) ([]jira.WorklogRecord, error) {
+	last := 0
+	var wlrs []jira.WorklogRecord
+	for {
+		opt := worklogOpts{
+			SearchOptions: jira.SearchOptions{
+				MaxResults: 5000, // max 5000
+				StartAt:    last,
+			},
+			StartedAfter: since.UnixMilli(),
+			Expand:       "properties",
+		}
+		worklog, resp, err := j.c.Issue.GetWorklogs(ctx, issueKey,
+			jira.WithQueryOptions(&opt))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't search: %v", err)
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("bad response %d: %v", resp.StatusCode, resp.Status)
+		}
+		wlrs = append(wlrs, worklog.Worklogs...)
+		last = resp.StartAt + len(worklog.Worklogs)
+		// return if we have paged through all records otherwise get the next page
+		// NOTE: the jira library is buggy and returns resp.Total == 0 here, so
+		// check for "last page" indirectly.
+		if len(worklog.Worklogs) < opt.MaxResults {
+			return wlrs, nil
+		}
+	}
+}
+
+// AddWorklog implements the Jira interface.
+func (j *goJira) AddWorklog(ctx context.Context, issueKey string,
+	wlr *WorklogRecord) (*WorklogRecord, error) {
+	req, err := j.c.NewRequest(ctx, http.MethodPost,
+		fmt.Sprintf("rest/api/2/issue/%s/worklog", url.PathEscape(issueKey)), wlr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	var record WorklogRecord
+	if _, err = j.c.Do(req, &record); err != nil {
+		return nil, fmt.Errorf("couldn't add worklog: %v", err)
+	}
+	return &record, nil
+}
+
+// DeleteWorklog implements the Jira interface.
+func (j *goJira) DeleteWorklog(ctx context.Context, issueKey,
+	worklogID string) error {
+	req, err := j.c.NewRequest(ctx, http.MethodDelete,
+		fmt.Sprintf("rest/api/2/issue/%s/worklog/%s",
+			url.PathEscape(issueKey), url.PathEscape(worklogID)), nil)
+	if err != nil {
+		return fmt.Errorf("couldn't construct request: %v", err)
+	}
+	resp, err := j.c.Do(req, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't delete worklog: %v", err)
+	}
+	return resp.Body.Close()
+}
+
+// TimeTrackingEnabled implements the Jira interface. The global settings are
+// used rather than the time tracking provider, which requires the Administer
+// Jira global permission.
+func (j *goJira) TimeTrackingEnabled(ctx context.Context) (bool, error) {
+	req, err := j.c.NewRequest(ctx, http.MethodGet,
+		"rest/api/2/configuration", nil)
+	if err != nil {
+		return false, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	var conf struct {
+		TimeTrackingEnabled bool `json:"timeTrackingEnabled"`
+	}
+	if _, err = j.c.Do(req, &conf); err != nil {
+		return false, fmt.Errorf("couldn't get global settings: %v", err)
+	}
+	return conf.TimeTrackingEnabled, nil
+}
+
+// HavePermission implements the Jira interface.
+func (j *goJira) HavePermission(ctx context.Context, projectKey,
+	permission string) (bool, error) {
+	query := url.Values{}
+	query.Set("projectKey", projectKey)
+	query.Set("permissions", permission)
+	req, err := j.c.NewRequest(ctx, http.MethodGet,
+		"rest/api/2/mypermissions?"+query.Encode(), nil)
+	if err != nil {
+		return false, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	perms := struct {
+		Permissions map[string]struct {
+			HavePermission bool `json:"havePermission"`
+		} `json:"permissions"`
+	}{}
+	if _, err = j.c.Do(req, &perms); err != nil {
+		return false, fmt.Errorf("couldn't get permissions: %v", err)
+	}
+	return perms.Permissions[permission].HavePermission, nil
+}
+
+// GroupExists implements the Jira interface.
+func (j *goJira) GroupExists(ctx context.Context, name string) (bool, error) {
+	query := url.Values{}
+	query.Set("query", name)
+	req, err := j.c.NewRequest(ctx, http.MethodGet,
+		"rest/api/2/groups/picker?"+query.Encode(), nil)
+	if err != nil {
+		return false, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	groups := struct {
+		Groups []struct {
+			Name string `json:"name"`
+		} `json:"groups"`
+	}{}
+	if _, err = j.c.Do(req, &groups); err != nil {
+		return false, fmt.Errorf("couldn't find groups: %v", err)
+	}
+	for _, group := range groups.Groups {
+		// group names are case-insensitive
+		if strings.EqualFold(group.Name, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ProjectRoles implements the Jira interface.
+func (j *goJira) ProjectRoles(ctx context.Context,
+	projectKey string) ([]string, error) {
+	req, err := j.c.NewRequest(ctx, http.MethodGet,
+		fmt.Sprintf("rest/api/2/project/%s/role", url.PathEscape(projectKey)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct request: %v", err)
+	}
+	// the response maps role names to role URLs
+	var roles map[string]string
+	if _, err = j.c.Do(req, &roles); err != nil {
+		return nil, fmt.Errorf("couldn't get project roles: %v", err)
+	}
+	return slices.Sorted(maps.Keys(roles)), nil
+}
+
+// Myself implements the Jira interface.
+func (j *goJira) Myself(ctx context.Context) (*jira.User, error) {
+	user, _, err := j.c.User.GetCurrentUser(ctx)
+	return user, err
+}
@@ -0,0 +1,278 @@
+// Package jiratest implements an in-memory fake Jira for testing.
+package jiratest
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/client"
+)
+
+var _ client.Jira = (*Fake)(nil)
+
+var (
+	keyInJQL         = regexp.MustCompile(`^key in \((.*)\)$`)
+	worklogAuthorJQL = regexp.MustCompile(
+		`^worklogAuthor = currentUser\(\) AND worklogDate >= "([0-9-]+)"$`)
+)
+
+// statusCategoryNames maps status category keys to names.
+var statusCategoryNames = map[string]string{
+	"new":           "To Do",
+	"indeterminate": "In Progress",
+	"done":          "Done",
+}
+
+// Fake is an in-memory implementation of the client.Jira interface. The
+// exported fields may be set to configure its behaviour before use.
+type Fake struct {
+	// CurrentUser is the author of worklogs added via AddWorklog.
+	CurrentUser jira.User
+	// TimeTrackingDisabled disables time tracking.
+	TimeTrackingDisabled bool
+	// Admin grants the current user the Administer Jira global permission.
+	Admin bool
+	// NoPermission lists the projects in which the current user has no
+	// permissions.
+	NoPermission []string
+	// AddWorklogErrors maps issue keys to errors returned by AddWorklog.
+	AddWorklogErrors map[string]error
+	// Groups lists the names of the groups which exist.
+	Groups []string
+	// Roles maps project keys to the names of the roles in the project.
+	Roles map[string][]string
+
+	mu       sync.Mutex
+	issues   map[string]jira.Issue
+	moved    map[string]string
+	worklogs map[string][]client.WorklogRecord
+	nextID   int
+}
+
+// New returns a new Fake with no issues.
+func New() *Fake {
+	return &Fake{
+		CurrentUser: jira.User{
+			AccountID:    "000000:00000000-0000-0000-0000-000000000000",
+			EmailAddress: "user@example.com",
+		},
+		issues:   map[string]jira.Issue{},
+		moved:    map[string]string{},
+		worklogs: map[string][]client.WorklogRecord{},
+		nextID:   10000,
+	}
+}
+
+// AddIssue adds an issue with the given key and summary in the status
+// category with the given key (new, indeterminate, or done).
+func (f *Fake) AddIssue(key, summary, statusCategory string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	name := statusCategoryNames[statusCategory]
+	f.issues[key] = jira.Issue{
+		ID:  strconv.Itoa(f.nextID),
+		Key: key,
+		Fields: &jira.IssueFields{
+			Summary: summary,
+			Status: &jira.Status{
+				Name: name,
+				StatusCategory: jira.StatusCategory{
+					Key:  statusCategory,
+					Name: name,
+				},
+			},
+			Project: jira.Project{Key: strings.Split(key, "-")[0]},
+		},
+	}
+}
+
+// MoveIssue changes the key of an existing issue. The old key continues to
+// resolve to the issue in GetIssue.
+func (f *Fake) MoveIssue(oldKey, newKey string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issue := f.issues[oldKey]
+	delete(f.issues, oldKey)
+	issue.Key = newKey
+	issue.Fields.Project.Key = strings.Split(newKey, "-")[0]
+	f.issues[newKey] = issue
+	f.moved[oldKey] = newKey
+	f.worklogs[newKey] = f.worklogs[oldKey]
+	delete(f.worklogs, oldKey)
+}
+
+// Worklogs returns the worklog records on the issue with the given key.
+func (f *Fake) Worklogs(key string) []client.WorklogRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.worklogs[f.resolve(key)])
+}
+
+// resolve returns the current key of the issue with the given key. The caller
+// must hold f.mu.
+func (f *Fake) resolve(key string) string {
+	key = strings.ToUpper(key)
+	if newKey, ok := f.moved[key]; ok {
+		return newKey
+	}
+	return key
+}
+
+// isCurrentUser returns true if the given user is the current user.
+func (f *Fake) isCurrentUser(u *jira.User) bool {
+	return u != nil && u.AccountID == f.CurrentUser.AccountID
+}
+
+// SearchIssues implements the client.Jira interface. It only supports the
+// JQL queries used by jiratime. Like Jira, it rejects queries for keys which
+// don't exist.
+func (f *Fake) SearchIssues(_ context.Context, jql string,
+	_ []string) ([]jira.Issue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if matches := keyInJQL.FindStringSubmatch(jql); matches != nil {
+		var issues []jira.Issue
+		for _, quoted := range strings.Split(matches[1], ", ") {
+			key, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("bad key %s: %v", quoted, err)
+			}
+			issue, ok := f.issues[strings.ToUpper(key)]
+			if !ok {
+				return nil, fmt.Errorf("400: issue %s does not exist", key)
+			}
+			issues = append(issues, issue)
+		}
+		return issues, nil
+	}
+	if matches := worklogAuthorJQL.FindStringSubmatch(jql); matches != nil {
+		since, err := time.ParseInLocation("2006-01-02", matches[1], time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("bad date %s: %v", matches[1], err)
+		}
+		var issues []jira.Issue
+		for _, key := range slices.Sorted(maps.Keys(f.worklogs)) {
+			if slices.ContainsFunc(f.worklogs[key], func(wlr client.WorklogRecord) bool {
+				return f.isCurrentUser(wlr.Author) &&
+					!time.Time(*wlr.Started).Before(since)
+			}) {
+				issues = append(issues, f.issues[key])
+			}
+		}
+		return issues, nil
+	}
+	return nil, fmt.Errorf("unsupported JQL: %s", jql)
+}
+
+// GetIssue implements the client.Jira interface.
+func (f *Fake) GetIssue(_ context.Context, key string,
+	_ []string) (*jira.Issue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issue, ok := f.issues[f.resolve(key)]
+	if !ok {
+		return nil, fmt.Errorf("404: issue %s does not exist", key)
+	}
+	return &issue, nil
+}
+
+// GetWorklogs implements the client.Jira interface.
+func (f *Fake) GetWorklogs(_ context.Context, issueKey string,
+	since time.Time) ([]jira.WorklogRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := f.resolve(issueKey)
+	if _, ok := f.issues[key]; !ok {
+		return nil, fmt.Errorf("404: issue %s does not exist", issueKey)
+	}
+	var wlrs []jira.WorklogRecord
+	for _, wlr := range f.worklogs[key] {
+		// like Jira, the startedAfter bound is exclusive
+		if time.Time(*wlr.Started).After(since) {
+			wlrs = append(wlrs, wlr.WorklogRecord)
+		}
+	}
+	return wlrs, nil
+}
+
+// AddWorklog implements the client.Jira interface. If the given record has no
+// author, the current user is used.
+func (f *Fake) AddWorklog(_ context.Context, issueKey string,
+	wlr *client.WorklogRecord) (*client.WorklogRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.AddWorklogErrors[issueKey]; err != nil {
+		return nil, err
+	}
+	key := f.resolve(issueKey)
+	issue, ok := f.issues[key]
+	if !ok {
+		return nil, fmt.Errorf("404: issue %s does not exist", issueKey)
+	}
+	f.nextID++
+	record := *wlr
+	record.ID = strconv.Itoa(f.nextID)
+	record.IssueID = issue.ID
+	if record.Author == nil {
+		author := f.CurrentUser
+		record.Author = &author
+	}
+	f.worklogs[key] = append(f.worklogs[key], record)
+	return &record, nil
+}
+
+// DeleteWorklog implements the client.Jira interface.
+func (f *Fake) DeleteWorklog(_ context.Context, issueKey,
+	worklogID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := f.resolve(issueKey)
+	i := slices.IndexFunc(f.worklogs[key], func(wlr client.WorklogRecord) bool {
+		return wlr.ID == worklogID
+	})
+	if i < 0 {
+		return fmt.Errorf("404: worklog %s does not exist on issue %s",
+			worklogID, issueKey)
+	}
+	f.worklogs[key] = slices.Delete(f.worklogs[key], i, i+1)
+	return nil
+}
+
+// TimeTrackingEnabled implements the client.Jira interface.
+func (f *Fake) TimeTrackingEnabled(_ context.Context) (bool, error) {
+	return !f.TimeTrackingDisabled, nil
+}
+
+// HavePermission implements the client.Jira interface.
+func (f *Fake) HavePermission(_ context.Context, projectKey,
+	_ string) (bool, error) {
+	return !slices.Contains(f.NoPermission, projectKey), nil
+}
+
+// GroupExists implements the client.Jira interface.
+func (f *Fake) GroupExists(_ context.Context, name string) (bool, error) {
+	return slices.ContainsFunc(f.Groups, func(group string) bool {
+		return strings.EqualFold(group, name)
+	}), nil
+}
+
+// ProjectRoles implements the client.Jira interface.
+func (f *Fake) ProjectRoles(_ context.Context,
+	projectKey string) ([]string, error) {
+	return f.Roles[projectKey], nil
+}
+
+// Myself implements the client.Jira interface.
+func (f *Fake) Myself(_ context.Context) (*jira.User, error) {
+	user := f.CurrentUser
+	return &user, nil
+}
@@ -0,0 +1,579 @@
+package jiratest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/client"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// APIHost is the host of the Atlassian API gateway.
+	APIHost = "api.atlassian.com"
+	// AuthHost is the host of the Atlassian OAuth2 authorization server.
+	AuthHost = "auth.atlassian.com"
+)
+
+// site is a Jira Cloud site served by a Server.
+type site struct {
+	cloudID string
+	mux     *http.ServeMux
+}
+
+// Server is a fake Jira Cloud HTTP server. It serves one or more Jira sites,
+// each backed by a Fake, along with the Atlassian API gateway and OAuth2
+// token endpoint. Every request it receives is recorded.
+//
+// Clients should send requests to the real Atlassian URLs using the
+// http.RoundTripper returned by Transport, which redirects them to the
+// Server.
+type Server struct {
+	*httptest.Server
+
+	// User and APIKey are the accepted basic auth credentials.
+	User   string
+	APIKey string
+	// ClientID and Secret are the accepted OAuth2 client credentials.
+	ClientID string
+	Secret   string
+	// Code is the accepted OAuth2 authorization code.
+	Code string
+	// PAT is the accepted Jira Data Center personal access token.
+	PAT string
+	// TokenDelay delays responses from the OAuth2 token endpoint, to widen
+	// races between concurrent token refreshes.
+	TokenDelay time.Duration
+	// RateLimit is the number of following requests answered with 429 Too
+	// Many Requests, asking the client to retry immediately.
+	RateLimit int
+
+	mu           sync.Mutex
+	sites        map[string]*site
+	accessToken  string
+	refreshToken string
+	tokenSerial  int
+	requests     []string
+	// challenge is the PKCE code challenge given to Consent, if any.
+	challenge string
+}
+
+// NewServer starts and returns a new Server with no sites. The caller should
+// call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		User:         "user@example.com",
+		APIKey:       "api-key",
+		ClientID:     "client-id",
+		Secret:       "client-secret",
+		Code:         "auth-code",
+		PAT:          "personal-access-token",
+		sites:        map[string]*site{},
+		accessToken:  "access-token-0",
+		refreshToken: "refresh-token-0",
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddSite adds a Jira site with the given host name (e.g.
+// example.atlassian.net) and cloud ID, backed by the given Fake.
+func (s *Server) AddSite(host, cloudID string, f *Fake) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sites[host] = &site{cloudID: cloudID, mux: apiMux(f, false)}
+}
+
+// AddDataCenterSite adds a Jira Data Center site with the given host name,
+// backed by the given Fake. Data Center sites identify users by key rather
+// than account ID, so the account IDs of the users in the Fake are served as
+// user keys.
+func (s *Server) AddDataCenterSite(host string, f *Fake) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sites[host] = &site{mux: apiMux(f, true)}
+}
+
+// dataCenterUser returns the given user in Jira Data Center format.
+func dataCenterUser(u *jira.User) map[string]any {
+	return map[string]any{
+		"key":          u.AccountID,
+		"name":         strings.Split(u.EmailAddress, "@")[0],
+		"emailAddress": u.EmailAddress,
+		"displayName":  u.DisplayName,
+		"active":       u.Active,
+	}
+}
+
+// Tokens returns the currently valid OAuth2 access and refresh tokens.
+func (s *Server) Tokens() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accessToken, s.refreshToken
+}
+
+// Consent simulates the user visiting the given OAuth2 authorization URL and
+// either allowing or denying access. It returns the URL the browser is
+// redirected to. If the authorization URL has a PKCE code challenge, the
+// code verifier must be sent with the code.
+func (s *Server) Consent(authURL string, allow bool) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse authorization URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != s.ClientID {
+		return "", fmt.Errorf("unknown client ID: %s", q.Get("client_id"))
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse redirect URI: %v", err)
+	}
+	rq := url.Values{"state": {q.Get("state")}}
+	if allow {
+		if q.Get("code_challenge") != "" &&
+			q.Get("code_challenge_method") != "S256" {
+			return "", fmt.Errorf("unsupported code challenge method: %s",
+				q.Get("code_challenge_method"))
+		}
+		s.mu.Lock()
+		s.challenge = q.Get("code_challenge")
+		s.mu.Unlock()
+		rq.Set("code", s.Code)
+	} else {
+		rq.Set("error", "access_denied")
+		rq.Set("error_description", "User did not authorize the request")
+	}
+	redirect.RawQuery = rq.Encode()
+	return redirect.String(), nil
+}
+
+// Requests returns the requests received by the server, in order. Each
+// request is formatted as the method and URL, followed by the body on the
+// next line if it isn't empty. JSON and form bodies are normalised.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// redirectTransport implements the http.RoundTripper interface.
+type redirectTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+// RoundTrip sends the request to the target, preserving the original Host.
+func (rt *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Host = req.URL.Host
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return rt.next.RoundTrip(req)
+}
+
+// Transport returns an http.RoundTripper which sends all requests to the
+// Server, regardless of the request URL.
+func (s *Server) Transport() http.RoundTripper {
+	target, _ := url.Parse(s.URL)
+	return &redirectTransport{target: target, next: s.Client().Transport}
+}
+
+// normaliseBody returns the given request body in a canonical form.
+func normaliseBody(contentType string, body []byte) string {
+	switch {
+	case len(body) == 0:
+		return ""
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return values.Encode()
+		}
+	default:
+		var v any
+		if err := json.Unmarshal(body, &v); err == nil {
+			if normalised, err := json.Marshal(v); err == nil {
+				return string(normalised)
+			}
+		}
+	}
+	return string(body)
+}
+
+// record records the given request.
+func (s *Server) record(r *http.Request, body []byte) {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.Query().Encode(),
+	}
+	entry := r.Method + " " + u.String()
+	if b := normaliseBody(r.Header.Get("Content-Type"), body); b != "" {
+		entry += "\n" + b
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, entry)
+}
+
+// rateLimited returns true if the current request should be rate limited,
+// decrementing RateLimit.
+func (s *Server) rateLimited() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.RateLimit <= 0 {
+		return false
+	}
+	s.RateLimit--
+	return true
+}
+
+// serveHTTP routes requests by Host.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	s.record(r, body)
+	if s.rateLimited() {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	switch r.Host {
+	case AuthHost:
+		if r.Method == http.MethodPost && r.URL.Path == "/oauth/token" {
+			s.token(w, r)
+			return
+		}
+	case APIHost:
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/ex/jira/"), "/", 2)
+		if len(parts) == 2 {
+			if st := s.siteByCloudID(parts[0]); st != nil {
+				s.api(st, "/"+parts[1], w, r)
+				return
+			}
+		}
+	default:
+		s.mu.Lock()
+		st := s.sites[r.Host]
+		s.mu.Unlock()
+		if st == nil {
+			break
+		}
+		if r.URL.Path == "/_edge/tenant_info" {
+			writeJSON(w, http.StatusOK, map[string]string{"cloudId": st.cloudID})
+			return
+		}
+		s.api(st, r.URL.Path, w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// siteByCloudID returns the site with the given cloud ID, or nil.
+func (s *Server) siteByCloudID(cloudID string) *site {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.sites {
+		if st.cloudID == cloudID {
+			return st
+		}
+	}
+	return nil
+}
+
+// authorized returns true if the request has valid credentials.
+func (s *Server) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, apiKey, ok := r.BasicAuth(); ok {
+		return user == s.User && apiKey == s.APIKey
+	}
+	auth := r.Header.Get("Authorization")
+	return (s.accessToken != "" && auth == "Bearer "+s.accessToken) ||
+		auth == "Bearer "+s.PAT
+}
+
+// api serves a Jira REST API request at the given path.
+func (s *Server) api(st *site, path string, w http.ResponseWriter,
+	r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized,
+			map[string]string{"message": "Client must be authenticated"})
+		return
+	}
+	r = r.Clone(r.Context())
+	r.URL.Path = path
+	r.URL.RawPath = ""
+	st.mux.ServeHTTP(w, r)
+}
+
+// token serves an OAuth2 token request.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.TokenDelay)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if clientID != s.ClientID || secret != s.Secret {
+		writeJSON(w, http.StatusUnauthorized,
+			map[string]string{"error": "access_denied"})
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "refresh_token":
+		ok = s.refreshToken != "" &&
+			r.PostForm.Get("refresh_token") == s.refreshToken
+	case "authorization_code":
+		ok = r.PostForm.Get("code") == s.Code && (s.challenge == "" ||
+			oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) == s.challenge)
+	default:
+		ok = false
+	}
+	if !ok {
+		writeJSON(w, http.StatusForbidden, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "Unknown or invalid refresh token.",
+		})
+		return
+	}
+	// rotate tokens
+	s.tokenSerial++
+	s.accessToken = fmt.Sprintf("access-token-%d", s.tokenSerial)
+	s.refreshToken = fmt.Sprintf("refresh-token-%d", s.tokenSerial)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  s.accessToken,
+		"refresh_token": s.refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"scope":         "offline_access read:jira-work write:jira-work",
+	})
+}
+
+// writeJSON writes v to w as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Jira-style error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string][]string{"errorMessages": {err.Error()}})
+}
+
+// apiMux returns a handler for the subset of the Jira REST API used by
+// jiratime, backed by the given Fake. If dataCenter is true, users are served
+// in Jira Data Center format.
+func apiMux(f *Fake, dataCenter bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rest/api/2/myself",
+		func(w http.ResponseWriter, _ *http.Request) {
+			if dataCenter {
+				writeJSON(w, http.StatusOK, dataCenterUser(&f.CurrentUser))
+				return
+			}
+			writeJSON(w, http.StatusOK, f.CurrentUser)
+		})
+	search := func(w http.ResponseWriter, r *http.Request) {
+		jql, fields := r.URL.Query().Get("jql"), r.URL.Query().Get("fields")
+		if r.Method == http.MethodPost {
+			body := struct {
+				JQL    string   `json:"jql"`
+				Fields []string `json:"fields"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			jql, fields = body.JQL, strings.Join(body.Fields, ",")
+		}
+		issues, err := f.SearchIssues(r.Context(), jql, strings.Split(fields, ","))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"issues": issues})
+	}
+	mux.HandleFunc("GET /rest/api/3/search/jql", search)
+	mux.HandleFunc("POST /rest/api/3/search/jql", search)
+	// Jira Data Center search endpoint
+	mux.HandleFunc("GET /rest/api/2/search",
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			issues, err := f.SearchIssues(r.Context(), query.Get("jql"),
+				strings.Split(query.Get("fields"), ","))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			startAt, _ := strconv.Atoi(query.Get("startAt"))
+			writeJSON(w, http.StatusOK, map[string]any{
+				"startAt": startAt,
+				"total":   len(issues),
+				"issues":  issues[min(startAt, len(issues)):],
+			})
+		})
+	mux.HandleFunc("GET /rest/api/2/issue/{key}",
+		func(w http.ResponseWriter, r *http.Request) {
+			issue, err := f.GetIssue(r.Context(), r.PathValue("key"), nil)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, issue)
+		})
+	mux.HandleFunc("GET /rest/api/2/issue/{key}/worklog",
+		func(w http.ResponseWriter, r *http.Request) {
+			var since time.Time
+			if ms := r.URL.Query().Get("startedAfter"); ms != "" {
+				n, err := strconv.ParseInt(ms, 10, 64)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				since = time.UnixMilli(n)
+			}
+			wlrs, err := f.GetWorklogs(r.Context(), r.PathValue("key"), since)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			if dataCenter {
+				type record struct {
+					jira.WorklogRecord
+					Author map[string]any `json:"author,omitempty"`
+				}
+				records := []record{}
+				for _, wlr := range wlrs {
+					rec := record{WorklogRecord: wlr}
+					if wlr.Author != nil {
+						rec.Author = dataCenterUser(wlr.Author)
+					}
+					records = append(records, rec)
+				}
+				writeJSON(w, http.StatusOK, map[string]any{
+					"startAt":    0,
+					"maxResults": len(records),
+					"total":      len(records),
+					"worklogs":   records,
+				})
+				return
+			}
+			writeJSON(w, http.StatusOK, jira.Worklog{
+				MaxResults: len(wlrs),
+				Total:      len(wlrs),
+				Worklogs:   wlrs,
+			})
+		})
+	mux.HandleFunc("POST /rest/api/2/issue/{key}/worklog",
+		func(w http.ResponseWriter, r *http.Request) {
+			var wlr client.WorklogRecord
+			if err := json.NewDecoder(r.Body).Decode(&wlr); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			record, err := f.AddWorklog(r.Context(), r.PathValue("key"), &wlr)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, record)
+		})
+	mux.HandleFunc("DELETE /rest/api/2/issue/{key}/worklog/{id}",
+		func(w http.ResponseWriter, r *http.Request) {
+			err := f.DeleteWorklog(r.Context(), r.PathValue("key"), r.PathValue("id"))
+			if err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	mux.HandleFunc("GET /rest/api/2/configuration",
+		func(w http.ResponseWriter, r *http.Request) {
+			enabled, _ := f.TimeTrackingEnabled(r.Context())
+			writeJSON(w, http.StatusOK, map[string]bool{
+				"timeTrackingEnabled": enabled,
+			})
+		})
+	// the time tracking provider is only available to administrators
+	mux.HandleFunc("GET /rest/api/2/configuration/timetracking",
+		func(w http.ResponseWriter, r *http.Request) {
+			if !f.Admin {
+				writeJSON(w, http.StatusForbidden, map[string][]string{
+					"errorMessages": {"You are not authorized to perform this action." +
+						" Administrator privileges are required."},
+				})
+				return
+			}
+			if enabled, _ := f.TimeTrackingEnabled(r.Context()); !enabled {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{
+				"key":  "JIRA",
+				"name": "JIRA provided time tracking",
+			})
+		})
+	mux.HandleFunc("GET /rest/api/2/groups/picker",
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query().Get("query")
+			type group struct {
+				Name string `json:"name"`
+			}
+			groups := []group{}
+			for _, name := range f.Groups {
+				if strings.Contains(strings.ToLower(name), strings.ToLower(query)) {
+					groups = append(groups, group{Name: name})
+				}
+			}
+			writeJSON(w, http.StatusOK, map[string]any{
+				"total":  len(groups),
+				"groups": groups,
+			})
+		})
+	mux.HandleFunc("GET /rest/api/2/project/{key}/role",
+		func(w http.ResponseWriter, r *http.Request) {
+			names, _ := f.ProjectRoles(r.Context(), r.PathValue("key"))
+			roles := map[string]string{}
+			for i, name := range names {
+				roles[name] = fmt.Sprintf("https://%s/rest/api/2/project/%s/role/%d",
+					r.Host, r.PathValue("key"), 10000+i)
+			}
+			writeJSON(w, http.StatusOK, roles)
+		})
+	mux.HandleFunc("GET /rest/api/2/mypermissions",
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			permissions := map[string]any{}
+			for _, p := range strings.Split(query.Get("permissions"), ",") {
+				ok, err := f.HavePermission(r.Context(), query.Get("projectKey"), p)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				permissions[p] = map[string]any{"key": p, "havePermission": ok}
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"permissions": permissions})
+		})
+	return mux
+}
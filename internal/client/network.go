@@ -0,0 +1,130 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// defaultTimeout is the default maximum duration of a single request.
+	defaultTimeout = 30 * time.Second
+	// defaultConnectTimeout is the default maximum duration of establishing
+	// a connection.
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// NewTransport returns the base http.RoundTripper configured by the given
+// network settings. If no proxy, certificates, or connect timeout are
+// configured, http.DefaultTransport is returned.
+func NewTransport(n *config.Network) (http.RoundTripper, error) {
+	if n == nil || (n.ProxyURL == "" && n.CABundle == "" && n.ClientCert == "" &&
+		n.ConnectTimeout == nil) {
+		return http.DefaultTransport, nil
+	}
+	connectTimeout := defaultConnectTimeout
+	if n.ConnectTimeout != nil {
+		connectTimeout = n.ConnectTimeout.Duration
+	}
+	// these settings follow http.DefaultTransport
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   connectTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	if n.ProxyURL != "" {
+		proxyURL, err := url.Parse(n.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse proxy URL: %v", err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	if n.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get system cert pool: %v", err)
+		}
+		pem, err := os.ReadFile(n.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA bundle: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("couldn't find any certificates in CA bundle %s",
+				n.CABundle)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if n.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(n.ClientCert, n.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %v", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return t, nil
+}
+
+// TransportWrapper wraps the base http.RoundTripper of an HTTP client, below
+// retries and authentication, so that it handles every request sent,
+// including retried requests and OAuth2 token refreshes. It is used to trace
+// requests.
+type TransportWrapper func(http.RoundTripper) http.RoundTripper
+
+// newBaseTransport returns the base http.RoundTripper configured by the given
+// network settings, wrapped by wrap if it is not nil.
+func newBaseTransport(
+	n *config.Network,
+	wrap TransportWrapper,
+) (http.RoundTripper, error) {
+	t, err := NewTransport(n)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct transport: %v", err)
+	}
+	if wrap != nil {
+		return wrap(t), nil
+	}
+	return t, nil
+}
+
+// requestTimeout returns the maximum duration of a single request configured
+// by the given network settings.
+func requestTimeout(n *config.Network) time.Duration {
+	if n == nil || n.Timeout == nil {
+		return defaultTimeout
+	}
+	return n.Timeout.Duration
+}
+
+// NewHTTPClient returns an unauthenticated http.Client configured by the
+// given network settings, which retries failed requests. The base transport
+// is wrapped by wrap if it is not nil.
+func NewHTTPClient(
+	log *slog.Logger,
+	n *config.Network,
+	wrap TransportWrapper,
+) (*http.Client, error) {
+	t, err := newBaseTransport(n, wrap)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   requestTimeout(n),
+		Transport: newRetryRoundTripper(log, t),
+	}, nil
+}
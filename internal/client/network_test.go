@@ -0,0 +1,152 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/smlx/jiratime/internal/config"
+)
+
+// writePEM writes a PEM block of the given type and bytes to a file in dir,
+// and returns the path.
+func writePEM(t *testing.T, dir, name, blockType string, b []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b})
+	assert.NoError(t, os.WriteFile(path, data, 0600), "write "+name)
+	return path
+}
+
+// newClientCert generates a self-signed client certificate and key, writes
+// them to dir, and returns the certificate and the paths.
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "GenerateKey")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "jiratime"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err, "CreateCertificate")
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err, "ParseCertificate")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "MarshalECPrivateKey")
+	return cert,
+		writePEM(t, dir, "client.crt", "CERTIFICATE", der),
+		writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+func TestNewTransport(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certPath, keyPath := newClientCert(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	// the server requires a client certificate signed by the client CA
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	caPath := writePEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+	var testCases = map[string]struct {
+		network   *config.Network
+		expectErr bool
+	}{
+		"no config": {
+			expectErr: true,
+		},
+		"CA bundle only": {
+			network:   &config.Network{CABundle: caPath},
+			expectErr: true,
+		},
+		"CA bundle and client certificate": {
+			network: &config.Network{
+				CABundle:   caPath,
+				ClientCert: certPath,
+				ClientKey:  keyPath,
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			// use the transport directly, since TLS errors are retried
+			rt, err := NewTransport(tc.network)
+			assert.NoError(tt, err, "NewTransport")
+			c := &http.Client{Transport: rt}
+			resp, err := c.Get(ts.URL)
+			if tc.expectErr {
+				assert.Error(tt, err, "Get")
+				return
+			}
+			assert.NoError(tt, err, "Get")
+			defer resp.Body.Close()
+			assert.Equal(tt, http.StatusNoContent, resp.StatusCode, "status")
+		})
+	}
+}
+
+func TestNewTransportProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer proxy.Close()
+	c, err := NewHTTPClient(discardLogger, &config.Network{ProxyURL: proxy.URL},
+		nil)
+	assert.NoError(t, err, "NewHTTPClient")
+	resp, err := c.Get("http://jira.example.com/rest/api/2/myself")
+	assert.NoError(t, err, "Get")
+	defer resp.Body.Close()
+	assert.Equal(t, "http://jira.example.com/rest/api/2/myself", proxied,
+		"proxied URL")
+}
+
+func TestNewTransportInvalid(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	assert.NoError(t, os.WriteFile(empty, nil, 0600), "write empty.pem")
+	var testCases = map[string]*config.Network{
+		"missing CA bundle": {CABundle: filepath.Join(dir, "missing.pem")},
+		"empty CA bundle":   {CABundle: empty},
+		"missing client certificate": {
+			ClientCert: filepath.Join(dir, "missing.crt"),
+			ClientKey:  filepath.Join(dir, "missing.key"),
+		},
+		"invalid proxy URL": {ProxyURL: "http://[::1"},
+	}
+	for name, n := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			_, err := NewTransport(n)
+			assert.Error(tt, err, "NewTransport")
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
+)
+
+// PropertyKey is the key of the entity property set on worklog records
+// created by jiratime.
+const PropertyKey = "jiratime"
+
+// propertySource is the source recorded in the worklog property.
+const propertySource = "jiratime"
+
+// WorklogProperty is the value of the entity property set on worklog records
+// created by jiratime.
+type WorklogProperty struct {
+	// Source is always "jiratime".
+	Source string `json:"source"`
+	// Version of jiratime which created the worklog record.
+	Version string `json:"version"`
+	// Hash of the timesheet entry content. See EntryHash.
+	Hash string `json:"hash"`
+	// TimesheetID identifies the timesheet the entry was submitted from.
+	TimesheetID string `json:"timesheetId"`
+}
+
+// EntryHash returns a hash identifying the content of a timesheet entry for
+// the issue with the given ID. It doesn't depend on the issue key, so it is
+// stable if the issue is moved, or on the local timezone.
+func EntryHash(issueID string, worklog parse.Worklog) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		issueID,
+		worklog.Started.UTC().Format(time.RFC3339),
+		fmt.Sprint(int(worklog.Duration.Seconds())),
+		worklog.Comment,
+	}, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetWorklogProperty returns the jiratime property of the given worklog
+// record, or nil if it has none.
+func GetWorklogProperty(wlr *jira.WorklogRecord) *WorklogProperty {
+	for _, p := range wlr.Properties {
+		if p.Key != PropertyKey {
+			continue
+		}
+		// the value is decoded as a generic map, so round trip it
+		buf, err := json.Marshal(p.Value)
+		if err != nil {
+			return nil
+		}
+		var wp WorklogProperty
+		if err = json.Unmarshal(buf, &wp); err != nil || wp.Source != propertySource {
+			return nil
+		}
+		return &wp
+	}
+	return nil
+}
+
+// markDuplicates sets Duplicate in each of the given results whose entry
+// hash matches the jiratime property of an existing worklog record on the
+// issue by the current user. Identical entries by other users, such as a
+// shared meeting, are not duplicates.
+func markDuplicates(ctx context.Context, log *slog.Logger, j Jira,
+	results []UploadResult, opts UploadOptions) error {
+	// find the earliest start time of the entries on each issue
+	since := map[string]time.Time{}
+	for _, result := range results {
+		key := result.key()
+		started := opts.shift(result.Worklog).Started
+		if t, ok := since[key]; !ok || started.Before(t) {
+			since[key] = started
+		}
+	}
+	var errs []error
+	hashes := map[string][]string{}
+	for _, key := range slices.Sorted(maps.Keys(since)) {
+		// Jira excludes worklogs started exactly at the startedAfter bound
+		wlrs, err := j.GetWorklogs(ctx, key, since[key].Add(-time.Minute))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't get worklogs on issue %s: %v",
+				key, err))
+			continue
+		}
+		for i := range wlrs {
+			if wlrs[i].Author == nil || wlrs[i].Author.AccountID != opts.AccountID {
+				continue
+			}
+			if wp := GetWorklogProperty(&wlrs[i]); wp != nil {
+				hashes[key] = append(hashes[key], wp.Hash)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	for i := range results {
+		if slices.Contains(hashes[results[i].key()], results[i].Hash) {
+			results[i].Duplicate = true
+			log.InfoCtx(ctx, "skipping duplicate worklog",
+				slog.String("issue", results[i].Issue),
+				slog.Time("started", opts.shift(results[i].Worklog).Started))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retryRoundTripper implements the http.RoundTripper interface. It retries
+// requests which fail due to rate limiting or server errors with exponential
+// backoff.
+type retryRoundTripper struct {
+	log       *slog.Logger
+	next      http.RoundTripper
+	retries   int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// newRetryRoundTripper wraps the given http.RoundTripper with retry logic.
+// Each attempt is logged to the given logger.
+func newRetryRoundTripper(
+	log *slog.Logger,
+	next http.RoundTripper,
+) *retryRoundTripper {
+	return &retryRoundTripper{
+		log:       log,
+		next:      next,
+		retries:   requestRetries,
+		baseDelay: retryBaseDelay,
+		maxDelay:  retryMaxDelay,
+	}
+}
+
+// idempotent returns true if the request can safely be sent more than once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// shouldRetry returns true if the request should be retried given the
+// response or error returned by the previous attempt.
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		// the request may or may not have reached the server
+		return idempotent(req)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// rate limited requests are not processed, so retry any method
+		return true
+	case resp.StatusCode == http.StatusInternalServerError,
+		resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return idempotent(req)
+	default:
+		return false
+	}
+}
+
+// retryAfter parses the Retry-After header of the given response, which may
+// be either a number of seconds or an HTTP date. It returns false if the
+// header is missing or invalid.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// delay returns the time to wait before the given retry attempt (zero-based).
+// It honours the Retry-After header if present, and otherwise uses
+// exponential backoff with jitter. The delay never exceeds maxDelay.
+func (rrt *retryRoundTripper) delay(attempt int, resp *http.Response) time.Duration {
+	if d, ok := retryAfter(resp, time.Now()); ok {
+		return min(d, rrt.maxDelay)
+	}
+	backoff := min(rrt.baseDelay<<attempt, rrt.maxDelay)
+	// "equal jitter": wait for at least half the backoff period
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// RoundTrip sends the request using the wrapped http.RoundTripper, retrying
+// as required. It gives up early if the request context deadline would be
+// exceeded before the next attempt.
+func (rrt *retryRoundTripper) RoundTrip(
+	req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		resp, err := rrt.next.RoundTrip(attemptReq)
+		rrt.logAttempt(req, attempt, time.Since(start), resp, err)
+		if attempt >= rrt.retries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+		// the request body must be replayable to retry
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+		wait := rrt.delay(attempt, resp)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+		rrt.log.WarnCtx(ctx, "retrying HTTP request",
+			slog.String("method", req.Method), slog.String("url", req.URL.String()),
+			slog.Int("retry", attempt+1), slog.Duration("delay", wait))
+		if resp != nil {
+			// drain the body so that the connection can be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			_ = resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		attemptReq = req.Clone(ctx)
+		if req.GetBody != nil {
+			attemptReq.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// logAttempt logs the outcome of a single attempt at the given request.
+func (rrt *retryRoundTripper) logAttempt(req *http.Request, attempt int,
+	duration time.Duration, resp *http.Response, err error) {
+	attrs := []any{
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.String()),
+		slog.Int("attempt", attempt+1),
+		slog.Duration("duration", duration),
+	}
+	if err != nil {
+		rrt.log.DebugCtx(req.Context(), "HTTP request failed",
+			append(attrs, slog.Any("error", err))...)
+		return
+	}
+	rrt.log.DebugCtx(req.Context(), "HTTP request",
+		append(attrs, slog.Int("status", resp.StatusCode))...)
+}
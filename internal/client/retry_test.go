@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"golang.org/x/exp/slog"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard))
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var testCases = map[string]struct {
+		header string
+		expect time.Duration
+		ok     bool
+	}{
+		"missing": {},
+		"seconds": {
+			header: "7",
+			expect: 7 * time.Second,
+			ok:     true,
+		},
+		"http date": {
+			header: now.Add(90 * time.Second).Format(http.TimeFormat),
+			expect: 90 * time.Second,
+			ok:     true,
+		},
+		"http date in the past": {
+			header: now.Add(-time.Minute).Format(http.TimeFormat),
+			expect: 0,
+			ok:     true,
+		},
+		"garbage": {
+			header: "soon",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			d, ok := retryAfter(resp, now)
+			assert.Equal(tt, tc.ok, ok, "ok")
+			assert.Equal(tt, tc.expect, d, "duration")
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	var testCases = map[string]struct {
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		"backoff": {
+			min: 250 * time.Millisecond,
+			max: 500 * time.Millisecond,
+		},
+		"retry after": {
+			header: "7",
+			min:    7 * time.Second,
+			max:    7 * time.Second,
+		},
+		"retry after clamped": {
+			header: "3600",
+			min:    retryMaxDelay,
+			max:    retryMaxDelay,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			rrt := newRetryRoundTripper(discardLogger, http.DefaultTransport)
+			resp := &http.Response{Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			d := rrt.delay(0, resp)
+			assert.True(tt, d >= tc.min && d <= tc.max, "delay %v", d)
+		})
+	}
+}
+
+func TestRetryRoundTripper(t *testing.T) {
+	var testCases = map[string]struct {
+		method       string
+		statuses     []int
+		expectStatus int
+		expectCalls  int32
+	}{
+		"success": {
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusOK},
+			expectStatus: http.StatusOK,
+			expectCalls:  1,
+		},
+		"rate limited GET": {
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			expectStatus: http.StatusOK,
+			expectCalls:  2,
+		},
+		"rate limited POST": {
+			method:       http.MethodPost,
+			statuses:     []int{http.StatusTooManyRequests, http.StatusCreated},
+			expectStatus: http.StatusCreated,
+			expectCalls:  2,
+		},
+		"server error GET": {
+			method: http.MethodGet,
+			statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable,
+				http.StatusOK},
+			expectStatus: http.StatusOK,
+			expectCalls:  3,
+		},
+		"server error POST not retried": {
+			method:       http.MethodPost,
+			statuses:     []int{http.StatusInternalServerError, http.StatusCreated},
+			expectStatus: http.StatusInternalServerError,
+			expectCalls:  1,
+		},
+		"client error not retried": {
+			method:       http.MethodGet,
+			statuses:     []int{http.StatusNotFound, http.StatusOK},
+			expectStatus: http.StatusNotFound,
+			expectCalls:  1,
+		},
+		"retries exhausted": {
+			method: http.MethodGet,
+			statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests,
+				http.StatusTooManyRequests},
+			expectStatus: http.StatusTooManyRequests,
+			expectCalls:  3,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					n := calls.Add(1)
+					body, _ := io.ReadAll(r.Body)
+					if r.Method == http.MethodPost {
+						assert.Equal(tt, "payload", string(body), "request body")
+					}
+					w.WriteHeader(tc.statuses[min(int(n), len(tc.statuses))-1])
+				}))
+			defer srv.Close()
+			c := &http.Client{Transport: &retryRoundTripper{
+				log:      discardLogger,
+				next:     http.DefaultTransport,
+				retries:  2,
+				maxDelay: time.Millisecond,
+			}}
+			req, err := http.NewRequest(tc.method, srv.URL,
+				strings.NewReader("payload"))
+			assert.NoError(tt, err, "new request")
+			resp, err := c.Do(req)
+			assert.NoError(tt, err, "do request")
+			_ = resp.Body.Close()
+			assert.Equal(tt, tc.expectStatus, resp.StatusCode, "status")
+			assert.Equal(tt, tc.expectCalls, calls.Load(), "calls")
+		})
+	}
+}
+
+func TestRetryRoundTripperDeadline(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+	defer srv.Close()
+	c := &http.Client{Transport: newRetryRoundTripper(discardLogger, http.DefaultTransport)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	assert.NoError(t, err, "new request")
+	resp, err := c.Do(req)
+	assert.NoError(t, err, "do request")
+	_ = resp.Body.Close()
+	// the Retry-After delay exceeds the deadline so no retry is made
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "status")
+	assert.Equal(t, int32(1), calls.Load(), "calls")
+}
+
+func TestRetryRoundTripperMaxDelay(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer srv.Close()
+	c := &http.Client{Transport: &retryRoundTripper{
+		log:      discardLogger,
+		next:     http.DefaultTransport,
+		retries:  2,
+		maxDelay: time.Millisecond,
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	assert.NoError(t, err, "new request")
+	resp, err := c.Do(req)
+	assert.NoError(t, err, "do request")
+	_ = resp.Body.Close()
+	// the Retry-After delay is clamped to maxDelay, so the retry is made
+	// within the deadline
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "status")
+	assert.Equal(t, int32(2), calls.Load(), "calls")
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
+	"golang.org/x/oauth2"
+)
+
+// persistingTokenSource implements the oauth2.TokenSource interface. It
+// refreshes the token in the credential store when it expires, writing each
+// new token back immediately so that a rotated refresh token is never lost.
+// Refreshes are serialized across processes by the lock of the store.
+type persistingTokenSource struct {
+	ctx   context.Context
+	log   *slog.Logger
+	conf  *oauth2.Config
+	store config.CredentialStore
+	// auth is the OAuth2 configuration written along with each new token.
+	auth config.OAuth2
+
+	mu  sync.Mutex
+	tok *oauth2.Token
+}
+
+// newPersistingTokenSource returns a persistingTokenSource which refreshes
+// tokens using the given oauth2.Config, and persists them to the given
+// store. auth.Token is the token already persisted.
+func newPersistingTokenSource(
+	ctx context.Context,
+	log *slog.Logger,
+	conf *oauth2.Config,
+	store config.CredentialStore,
+	auth *config.OAuth2,
+) *persistingTokenSource {
+	return &persistingTokenSource{
+		ctx:   ctx,
+		log:   log,
+		conf:  conf,
+		store: store,
+		auth:  *auth,
+		tok:   auth.Token,
+	}
+}
+
+// Token returns a valid token, refreshing and persisting it if required. A
+// failure to persist the token is logged rather than returned, since the
+// token is still valid for this process.
+func (ts *persistingTokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.tok.Valid() {
+		return ts.tok, nil
+	}
+	unlock, err := ts.store.LockOAuth2(ts.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't lock auth config: %v", err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			ts.log.Warn("couldn't unlock auth config", slog.Any("error", err))
+		}
+	}()
+	// another process may have refreshed the token while this one waited for
+	// the lock, in which case the refresh token held by this process has
+	// already been used.
+	current := ts.tok
+	if auth, err := ts.store.ReadOAuth2(); err != nil {
+		ts.log.Warn("couldn't re-read auth config", slog.Any("error", err))
+	} else if auth != nil && auth.Token != nil {
+		if auth.Token.Valid() {
+			ts.log.Debug("using OAuth2 token refreshed by another process")
+			ts.tok = auth.Token
+			return ts.tok, nil
+		}
+		current = auth.Token
+	}
+	tok, err := ts.conf.TokenSource(ts.ctx, current).Token()
+	if err != nil {
+		return nil, err
+	}
+	ts.tok = tok
+	if scope, ok := tok.Extra("scope").(string); ok && scope != "" {
+		ts.auth.Scope = scope
+	}
+	auth := ts.auth
+	auth.Token = tok
+	if err = ts.store.WriteOAuth2(&auth); err != nil {
+		ts.log.Error("couldn't persist refreshed OAuth2 token."+
+			" Run `jiratime authorize` if the next command fails.",
+			slog.Any("error", err))
+		return tok, nil
+	}
+	ts.log.Debug("persisted refreshed OAuth2 token",
+		slog.Time("expiry", tok.Expiry))
+	return tok, nil
+}
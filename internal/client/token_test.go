@@ -0,0 +1,70 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/alecthomas/assert/v2"
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/client/jiratest"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func TestOAuth2ConcurrentRefresh(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(t.TempDir(), "config"))
+	xdg.Reload()
+	srv := jiratest.NewServer()
+	srv.TokenDelay = 100 * time.Millisecond
+	srv.AddSite("example.atlassian.net", "cloud-id", newFake())
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Transport()
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+		srv.Close()
+	})
+	access, refresh := srv.Tokens()
+	assert.NoError(t, config.WriteAuth(&config.OAuth2{
+		ClientID: srv.ClientID,
+		Secret:   srv.Secret,
+		Token: &oauth2.Token{
+			AccessToken:  access,
+			TokenType:    "Bearer",
+			RefreshToken: refresh,
+			Expiry:       time.Now().Add(-time.Hour),
+		},
+	}), "write auth")
+	// each client simulates a separate process holding the expired token. the
+	// server rejects reused refresh tokens, so only one client may refresh.
+	var clients []*http.Client
+	for range 4 {
+		c, err := client.NewOAuth2HTTPClient(context.Background(),
+			discardLogger, nil, config.NewCredentialStore(nil, ""), nil)
+		assert.NoError(t, err, "new client")
+		clients = append(clients, c)
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(clients))
+	for i, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = client.CloudIDJiraURL(c, "https://example.atlassian.net/")
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err, "request")
+	}
+	auth, err := config.ReadAuth()
+	assert.NoError(t, err, "read auth")
+	access, refresh = srv.Tokens()
+	assert.Equal(t, "access-token-1", access, "refresh count")
+	assert.Equal(t, access, auth.Token.AccessToken, "access token")
+	assert.Equal(t, refresh, auth.Token.RefreshToken, "refresh token")
+}
@@ -3,83 +3,195 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net/http"
 	"net/url"
 	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	jira "github.com/andygrunwald/go-jira/v2/cloud"
 	"github.com/smlx/jiratime/internal/config"
 	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
 	"golang.org/x/oauth2"
 )
 
+// requestRetries is the maximum number of times a failed request is retried.
 const requestRetries = 4
 
 // authenticatedRoundTripper implements the http.RoundTripper interface
 type authenticatedRoundTripper struct {
 	username string
 	password string
+	next     http.RoundTripper
 }
 
 // RoundTrip sets the basic authentication header and then handles the request
-// using the http.DefaultTransport.
+// using the wrapped http.RoundTripper.
 func (art *authenticatedRoundTripper) RoundTrip(
 	req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
 	req.SetBasicAuth(art.username, art.password)
-	return http.DefaultTransport.RoundTrip(req)
+	return art.next.RoundTrip(req)
 }
 
-func NewBasicAuthHTTPClient() (*http.Client, string, bool, error) {
-	basic, err := config.ReadBasicAuth()
+// bearerRoundTripper implements the http.RoundTripper interface
+type bearerRoundTripper struct {
+	token string
+	next  http.RoundTripper
+}
+
+// RoundTrip sets the bearer token authorization header and then handles the
+// request using the wrapped http.RoundTripper.
+func (brt *bearerRoundTripper) RoundTrip(
+	req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+brt.token)
+	return brt.next.RoundTrip(req)
+}
+
+// NewTokenHTTPClient returns a http.Client configured by the given network
+// settings which authenticates using the given bearer token.
+func NewTokenHTTPClient(
+	log *slog.Logger,
+	n *config.Network,
+	token string,
+	wrap TransportWrapper,
+) (*http.Client, error) {
+	t, err := newBaseTransport(n, wrap)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: requestTimeout(n),
+		Transport: &bearerRoundTripper{
+			token: token,
+			next:  newRetryRoundTripper(log, t),
+		},
+	}, nil
+}
+
+// NewPATHTTPClient returns a http.Client which authenticates to Jira Data
+// Center using the given personal access token.
+func NewPATHTTPClient(
+	log *slog.Logger,
+	n *config.Network,
+	pat *config.PersonalAccessToken,
+	wrap TransportWrapper,
+) (*http.Client, error) {
+	return NewTokenHTTPClient(log, n, pat.Token, wrap)
+}
+
+// NewBasicAuthHTTPClient returns a http.Client which authenticates using the
+// basic auth credentials in the given store. It also returns true if the API
+// token is scoped.
+func NewBasicAuthHTTPClient(
+	log *slog.Logger,
+	n *config.Network,
+	store config.CredentialStore,
+	wrap TransportWrapper,
+) (*http.Client, bool, error) {
+	basic, err := store.ReadBasicAuth()
+	if err != nil {
+		return nil, false, fmt.Errorf("couldn't read basic auth: %v", err)
+	}
+	t, err := newBaseTransport(n, wrap)
 	if err != nil {
-		return nil, "", false, fmt.Errorf("couldn't read basic auth: %v", err)
+		return nil, false, err
 	}
 	// construct http.Client with automatic basic auth
 	return &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout: requestTimeout(n),
 		Transport: &authenticatedRoundTripper{
 			username: basic.User,
 			password: basic.APIKey,
+			next:     newRetryRoundTripper(log, t),
 		},
-	}, basic.User, basic.Scoped, nil
+	}, basic.Scoped, nil
 }
 
-func NewOAuth2HTTPClient(ctx context.Context) (*http.Client, oauth2.TokenSource, *config.OAuth2, error) {
+// NewOAuth2HTTPClient returns a http.Client which authenticates using the
+// OAuth2 client credentials in the given store and the token in auth.yml. The
+// token is refreshed as required, and each new token is written back to
+// auth.yml immediately.
+func NewOAuth2HTTPClient(
+	ctx context.Context,
+	log *slog.Logger,
+	n *config.Network,
+	store config.CredentialStore,
+	wrap TransportWrapper,
+) (*http.Client, error) {
 	// load the auth config to get the oauth2 token
-	auth, err := config.ReadAuth()
+	auth, err := store.ReadOAuth2()
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("couldn't load auth config: %v", err)
+		return nil, fmt.Errorf("couldn't load auth config: %v", err)
 	}
 	// sanity check that there is an access_token and refresh_token
 	if auth == nil {
-		return nil, nil, nil, fmt.Errorf("couldn't find oauth2 configuration")
+		return nil, fmt.Errorf("couldn't find oauth2 configuration")
 	}
-	if auth.Token.AccessToken == "" || auth.Token.RefreshToken == "" {
-		return nil, nil, nil, fmt.Errorf("missing access_token or refresh_token." +
+	if auth.Token == nil || auth.Token.AccessToken == "" ||
+		auth.Token.RefreshToken == "" {
+		return nil, fmt.Errorf("missing access_token or refresh_token." +
 			" Please run `authorize` to refresh tokens")
 	}
 	// create an http client using the oauth2 token. this will auto-refresh the
-	// token as required.
+	// token as required. both API requests and token refreshes are retried.
+	baseClient, err := NewHTTPClient(log, n, wrap)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't construct HTTP client: %v", err)
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, baseClient)
 	oauth2Conf := GetOAuth2Config(auth)
-	tokenSource := oauth2Conf.TokenSource(ctx, auth.Token)
+	tokenSource := newPersistingTokenSource(ctx, log, oauth2Conf, store,
+		auth)
 	httpClient := oauth2.NewClient(ctx, tokenSource)
-	return httpClient, tokenSource, auth, nil
+	httpClient.Timeout = requestTimeout(n)
+	return httpClient, nil
 }
 
+// CloudIDJiraURL returns the Atlassian API gateway URL of the Jira Cloud site
+// at the given URL, which is required by OAuth2 and scoped API tokens.
 func CloudIDJiraURL(client *http.Client, jiraURL string) (string, error) {
+	cloudID, err := CloudID(client, jiraURL)
+	if err != nil {
+		return "", err
+	}
+	return GatewayURL(cloudID), nil
+}
+
+// GatewayURL returns the Atlassian API gateway URL of the Jira Cloud site
+// with the given cloud ID.
+func GatewayURL(cloudID string) string {
+	return fmt.Sprintf("https://api.atlassian.com/ex/jira/%s", cloudID)
+}
+
+// TenantInfoURL returns the URL of the tenant info of the Jira Cloud site at
+// the given URL, which contains its cloud ID.
+func TenantInfoURL(jiraURL string) (*url.URL, error) {
+	ju, err := url.Parse(jiraURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse Jira URL: %v", err)
+	}
+	ju.Path = path.Join(ju.Path, "/_edge/tenant_info")
+	return ju, nil
+}
+
+// CloudID returns the cloud ID of the Jira Cloud site at the given URL.
+func CloudID(client *http.Client, jiraURL string) (string, error) {
 	tenantInfo := struct {
 		CloudID string `json:"cloudId"`
 	}{}
-	urlTmpl := "https://api.atlassian.com/ex/jira/%s"
-	ju, err := url.Parse(jiraURL)
+	ju, err := TenantInfoURL(jiraURL)
 	if err != nil {
-		return "", fmt.Errorf("couldn't parse Jira URL: %v", err)
+		return "", err
 	}
-	ju.Path = path.Join(ju.Path, "/_edge/tenant_info")
 	// get the cloud ID
 	resp, err := client.Get(ju.String())
 	if err != nil {
@@ -93,67 +205,352 @@ func CloudIDJiraURL(client *http.Client, jiraURL string) (string, error) {
 	if err = json.Unmarshal(data, &tenantInfo); err != nil {
 		return "", fmt.Errorf("couldn't unmrashal tenant info: %v", err)
 	}
-	return fmt.Sprintf(urlTmpl, tenantInfo.CloudID), nil
+	return tenantInfo.CloudID, nil
 }
 
-// UploadWorklogs uploads the given worklogs to Jira, with the
-// given day offset (e.g. -1 == yesterday).
-func UploadWorklogs(
+// rollbackTimeout is the maximum time allowed to roll back created worklogs
+// after a failed upload. It applies even if the upload context has expired.
+const rollbackTimeout = 30 * time.Second
+
+// ErrNotAttempted is the error recorded in an UploadResult for a worklog
+// which was not uploaded because an earlier upload failed.
+var ErrNotAttempted = errors.New("not attempted")
+
+// UploadResult is the outcome of uploading a single worklog entry.
+type UploadResult struct {
+	// Issue is the issue key given in the timesheet. If the issue has been
+	// moved, the worklog is added to the canonical key in Metadata.Key.
+	Issue    string
+	Metadata IssueMetadata
+	Worklog  parse.Worklog
+	// ID of the created worklog record. Empty in dry-run mode, on failure, or
+	// if the entry is a duplicate.
+	ID  string
+	Err error
+	// Hash of the entry content, recorded in the worklog property.
+	Hash string
+	// Duplicate is true if a worklog record with the same Hash already exists,
+	// so the entry was skipped.
+	Duplicate bool
+	// RolledBack is true if the created worklog record was deleted again
+	// because another upload failed.
+	RolledBack bool
+}
+
+// key returns the key of the issue the worklog is added to.
+func (r *UploadResult) key() string {
+	if r.Metadata.Key != "" {
+		return r.Metadata.Key
+	}
+	return r.Issue
+}
+
+// uploadEntries flattens the given issue-Worklog map into a slice of
+// UploadResults in a deterministic order: sorted by issue, and then in
+// timesheet order.
+func uploadEntries(issueWorklogs map[string][]parse.Worklog,
+	metadata map[string]IssueMetadata) []UploadResult {
+	var results []UploadResult
+	for _, issue := range slices.Sorted(maps.Keys(issueWorklogs)) {
+		for _, worklog := range issueWorklogs[issue] {
+			results = append(results, UploadResult{
+				Issue:    issue,
+				Metadata: metadata[issue],
+				Worklog:  worklog,
+			})
+		}
+	}
+	return results
+}
+
+// UploadOptions configures UploadWorklogs.
+type UploadOptions struct {
+	// DayOffset is added to the worklog start times (e.g. -1 == yesterday).
+	DayOffset int
+	// DryRun performs validation only, without adding any worklogs.
+	DryRun bool
+	// Concurrency is the maximum number of worklogs uploaded at once.
+	Concurrency int
+	// StatusCategories restricts the status categories of the issues which
+	// worklogs can be added to.
+	StatusCategories *config.StatusCategories
+	// Writer adds the worklogs. If nil, worklog records are added to the Jira
+	// issues.
+	Writer WorklogWriter
+	// Version and TimesheetID are recorded in the jiratime property of added
+	// Jira worklog records.
+	Version     string
+	TimesheetID string
+	// AllowDuplicates disables skipping entries which match the jiratime
+	// property of an existing Jira worklog record.
+	AllowDuplicates bool
+	// AccountID is the account ID of the current user. Only the worklog
+	// records of this user are considered when skipping duplicates.
+	AccountID string
+}
+
+// shift returns the given worklog with the DayOffset added to its start
+// time.
+func (opts *UploadOptions) shift(worklog parse.Worklog) parse.Worklog {
+	return worklog.Shift(opts.DayOffset)
+}
+
+// WorklogWriter adds worklogs to a time tracking backend.
+type WorklogWriter interface {
+	// AddWorklog adds the worklog for the given issue and returns the ID of the
+	// created worklog. issue is the issue key given in the timesheet.
+	AddWorklog(ctx context.Context, issue string, meta IssueMetadata,
+		worklog parse.Worklog) (string, error)
+	// DeleteWorklog deletes the worklog with the given ID, which was created by
+	// AddWorklog.
+	DeleteWorklog(ctx context.Context, meta IssueMetadata, id string) error
+	// SupportsVisibility returns true if the visibility of worklogs can be
+	// restricted.
+	SupportsVisibility() bool
+}
+
+// worklogVisibility converts the given configured visibility to a
+// WorklogVisibility.
+func worklogVisibility(v *config.Visibility) *WorklogVisibility {
+	switch {
+	case v == nil:
+		return nil
+	case v.Group != "":
+		return &WorklogVisibility{Type: "group", Value: v.Group}
+	default:
+		return &WorklogVisibility{Type: "role", Value: v.Role}
+	}
+}
+
+// jiraWriter implements the WorklogWriter interface by adding worklog
+// records to Jira issues.
+type jiraWriter struct {
+	j           Jira
+	version     string
+	timesheetID string
+}
+
+// AddWorklog implements the WorklogWriter interface.
+func (w *jiraWriter) AddWorklog(ctx context.Context, _ string,
+	meta IssueMetadata, worklog parse.Worklog) (string, error) {
+	started := jira.Time(worklog.Started)
+	record, err := w.j.AddWorklog(ctx, meta.Key, &WorklogRecord{
+		WorklogRecord: jira.WorklogRecord{
+			Comment:          worklog.Comment,
+			TimeSpentSeconds: int(worklog.Duration.Seconds()),
+			Started:          &started,
+			Properties: []jira.EntityProperty{{
+				Key: PropertyKey,
+				Value: WorklogProperty{
+					Source:      propertySource,
+					Version:     w.version,
+					Hash:        EntryHash(meta.ID, worklog),
+					TimesheetID: w.timesheetID,
+				},
+			}},
+		},
+		Visibility: worklogVisibility(worklog.Visibility),
+	})
+	if err != nil {
+		return "", err
+	}
+	return record.ID, nil
+}
+
+// DeleteWorklog implements the WorklogWriter interface.
+func (w *jiraWriter) DeleteWorklog(ctx context.Context, meta IssueMetadata,
+	id string) error {
+	return w.j.DeleteWorklog(ctx, meta.Key, id)
+}
+
+// SupportsVisibility implements the WorklogWriter interface.
+func (w *jiraWriter) SupportsVisibility() bool {
+	return true
+}
+
+// addWorklogs adds the worklogs in results using the given writer, recording
+// the outcome in each result. It returns true if all the worklogs were added.
+func addWorklogs(ctx context.Context, log *slog.Logger, w WorklogWriter,
+	results []UploadResult, opts UploadOptions) bool {
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(opts.Concurrency, 1))
+	for i := range results {
+		if results[i].Duplicate {
+			continue
+		}
+		sem <- struct{}{}
+		if failed.Load() {
+			<-sem
+			results[i].Err = ErrNotAttempted
+			continue
+		}
+		wg.Add(1)
+		go func(result *UploadResult) {
+			defer func() { <-sem; wg.Done() }()
+			worklog := opts.shift(result.Worklog)
+			meta := result.Metadata
+			meta.Key = result.key()
+			id, err := w.AddWorklog(ctx, result.Issue, meta, worklog)
+			if err != nil {
+				log.ErrorCtx(ctx, "couldn't add worklog", slog.String("issue", meta.Key),
+					slog.Time("started", worklog.Started), slog.Any("error", err))
+				result.Err = err
+				failed.Store(true)
+				return
+			}
+			log.DebugCtx(ctx, "added worklog", slog.String("issue", meta.Key),
+				slog.String("id", id), slog.Time("started", worklog.Started),
+				slog.Duration("duration", worklog.Duration))
+			result.ID = id
+		}(&results[i])
+	}
+	wg.Wait()
+	return !failed.Load()
+}
+
+// rollback deletes the worklogs which were created by addWorklogs.
+func rollback(ctx context.Context, log *slog.Logger, w WorklogWriter,
+	results []UploadResult) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	var errs []error
+	for i, result := range results {
+		if result.ID == "" {
+			continue
+		}
+		meta := result.Metadata
+		meta.Key = result.key()
+		if err := w.DeleteWorklog(ctx, meta, result.ID); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"couldn't delete worklog record %s from issue %s: %v",
+				result.ID, result.key(), err))
+			continue
+		}
+		log.InfoCtx(ctx, "rolled back worklog", slog.String("issue", meta.Key),
+			slog.String("id", result.ID))
+		results[i].RolledBack = true
+	}
+	return errors.Join(errs...)
+}
+
+// writer returns the WorklogWriter configured in opts, defaulting to Jira.
+func (opts *UploadOptions) writer(j Jira) WorklogWriter {
+	if opts.Writer != nil {
+		return opts.Writer
+	}
+	return &jiraWriter{j: j, version: opts.Version, timesheetID: opts.TimesheetID}
+}
+
+// PrepareUpload checks that all the issues in the given worklogs exist and
+// can have worklogs added, and returns the result for each worklog entry
+// ready to be passed to AddWorklogs. It doesn't add any worklogs.
+func PrepareUpload(
 	ctx context.Context,
-	c *jira.Client,
+	log *slog.Logger,
+	j Jira,
 	issueWorklogs map[string][]parse.Worklog,
-	dayOffset int,
-	dryRun bool,
-) error {
+	opts UploadOptions,
+) ([]UploadResult, error) {
 	// check that all the issues in worklogs exist
-	var success bool
-	for issue := range issueWorklogs {
-		success = false
-		var err error
-		var response *jira.Response
-		for range requestRetries {
-			_, response, err = c.Issue.Get(ctx, issue, nil)
-			if err == nil {
-				success = true
-				break // successful get
-			}
-			if response != nil && response.StatusCode == http.StatusUnauthorized {
-				continue
-			}
-			return fmt.Errorf("couldn't get Jira issue %s: %v", issue, err)
+	metadata, err := validateIssues(ctx, log, j, slices.Collect(maps.Keys(issueWorklogs)))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't validate issues: %w", err)
+	}
+	// check that worklogs can be added to the issues
+	err = checkIssues(ctx, j, metadata, opts.StatusCategories)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't add worklogs to issues: %w", err)
+	}
+	// warn about moved issues
+	for _, issue := range slices.Sorted(maps.Keys(metadata)) {
+		if meta := metadata[issue]; meta.moved(issue) {
+			log.WarnCtx(ctx, "issue has moved: uploading worklogs to the new key."+
+				" Run `jiratime update-issue-keys` to update issue IDs in config.",
+				slog.String("issue", issue), slog.String("key", meta.Key))
 		}
-		if !success {
-			return fmt.Errorf("couldn't get Jira issue %s after %d retries: %v",
-				issue, requestRetries, err)
+	}
+	results := uploadEntries(issueWorklogs, metadata)
+	for i := range results {
+		results[i].Hash = EntryHash(results[i].Metadata.ID, opts.shift(results[i].Worklog))
+	}
+	// check that the worklog visibility groups and roles exist
+	if err = checkVisibility(ctx, j, opts.writer(j), results); err != nil {
+		return nil, fmt.Errorf("couldn't restrict worklog visibility: %w", err)
+	}
+	// skip entries which have already been added to Jira
+	if opts.Writer == nil && !opts.AllowDuplicates {
+		if err = markDuplicates(ctx, log, j, results, opts); err != nil {
+			return nil, fmt.Errorf("couldn't check for duplicate worklogs: %w", err)
 		}
 	}
-	// exit early in dry-run mode
-	if dryRun {
-		log.Println("dry-run mode: not submitting any work logs")
+	return results, nil
+}
+
+// AddWorklogs adds the worklogs in the given results returned by
+// PrepareUpload, recording the outcome in each result. Once an upload fails
+// no further uploads are started, the remaining entries have ErrNotAttempted
+// as their error, and any worklogs already created are deleted again.
+func AddWorklogs(
+	ctx context.Context,
+	log *slog.Logger,
+	j Jira,
+	results []UploadResult,
+	opts UploadOptions,
+) error {
+	w := opts.writer(j)
+	if addWorklogs(ctx, log, w, results, opts) {
 		return nil
 	}
-	// add the worklogs to the issues
-	for issue, worklogs := range issueWorklogs {
-		for _, worklog := range worklogs {
-			started := jira.Time(
-				worklog.Started.Add(time.Hour * 24 * time.Duration(dayOffset)))
-			wr := jira.WorklogRecord{
-				Comment:          worklog.Comment,
-				TimeSpentSeconds: int(worklog.Duration.Seconds()),
-				Started:          &started,
-			}
-		retryAddWorklog:
-			for range requestRetries {
-				_, response, err := c.Issue.AddWorklogRecord(ctx, issue, &wr)
-				if err == nil {
-					break retryAddWorklog
-				}
-				if response.StatusCode == http.StatusNotFound {
-					continue
-				}
-				return fmt.Errorf("couldn't add worklog record to issue %s: %v", issue, err)
-			}
+	// report errors in entry order
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil && result.Err != ErrNotAttempted {
+			errs = append(errs, fmt.Errorf(
+				"couldn't add worklog record to issue %s: %v", result.Issue, result.Err))
 		}
 	}
-	return nil
+	if err := rollback(ctx, log, w, results); err != nil {
+		errs = append(errs, fmt.Errorf("couldn't roll back: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// RollbackWorklogs deletes the worklogs created by a successful call to
+// AddWorklogs. It is used when a later step fails after the worklogs have
+// been added.
+func RollbackWorklogs(
+	ctx context.Context,
+	log *slog.Logger,
+	j Jira,
+	results []UploadResult,
+	opts UploadOptions,
+) error {
+	return rollback(ctx, log, opts.writer(j), results)
+}
+
+// UploadWorklogs uploads the given worklogs to Jira. Before uploading any
+// worklogs it checks that all the issues exist and can have worklogs added.
+//
+// If all the issues exist, the returned slice contains the result for each
+// worklog entry even if an error is returned. Once an upload fails no further
+// uploads are started, the remaining entries have ErrNotAttempted as their
+// error, and any worklogs already created are deleted again.
+func UploadWorklogs(
+	ctx context.Context,
+	log *slog.Logger,
+	j Jira,
+	issueWorklogs map[string][]parse.Worklog,
+	opts UploadOptions,
+) ([]UploadResult, error) {
+	results, err := PrepareUpload(ctx, log, j, issueWorklogs, opts)
+	if err != nil {
+		return nil, err
+	}
+	// exit early in dry-run mode
+	if opts.DryRun {
+		log.InfoCtx(ctx, "dry-run mode: not submitting any work logs")
+		return results, nil
+	}
+	return results, AddWorklogs(ctx, log, j, results, opts)
 }
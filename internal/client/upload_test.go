@@ -0,0 +1,441 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/client/jiratest"
+	"github.com/smlx/jiratime/internal/config"
+	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard))
+
+// newFake returns a jiratest.Fake with some issues.
+func newFake() *jiratest.Fake {
+	f := jiratest.New()
+	f.AddIssue("ABC-1", "admin", "indeterminate")
+	f.AddIssue("ABC-2", "on-call", "new")
+	f.AddIssue("XYZ-3", "finished project", "done")
+	return f
+}
+
+func TestUploadWorklogs(t *testing.T) {
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	worklog := func(offset time.Duration, comment string) parse.Worklog {
+		return parse.Worklog{
+			Started:  started.Add(offset),
+			Duration: 15 * time.Minute,
+			Comment:  comment,
+		}
+	}
+	var testCases = map[string]struct {
+		setup          func(*jiratest.Fake)
+		input          map[string][]parse.Worklog
+		opts           client.UploadOptions
+		expectErr      []string
+		expectResults  int
+		expectWorklogs map[string]int
+	}{
+		"success": {
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one"), worklog(time.Hour, "two")},
+				"ABC-2": {worklog(30*time.Minute, "three")},
+			},
+			opts:           client.UploadOptions{Concurrency: 2},
+			expectResults:  3,
+			expectWorklogs: map[string]int{"ABC-1": 2, "ABC-2": 1},
+		},
+		"dry run": {
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one")},
+			},
+			opts:           client.UploadOptions{DryRun: true},
+			expectResults:  1,
+			expectWorklogs: map[string]int{"ABC-1": 0},
+		},
+		"lowercase key": {
+			input: map[string][]parse.Worklog{
+				"abc-1": {worklog(0, "one")},
+			},
+			expectResults:  1,
+			expectWorklogs: map[string]int{"ABC-1": 1},
+		},
+		"moved issue": {
+			setup: func(f *jiratest.Fake) { f.MoveIssue("ABC-2", "DEF-9") },
+			input: map[string][]parse.Worklog{
+				"ABC-2": {worklog(0, "one")},
+			},
+			expectResults:  1,
+			expectWorklogs: map[string]int{"DEF-9": 1},
+		},
+		"missing issues": {
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one")},
+				"ABC-8": {worklog(0, "two")},
+				"ABC-9": {worklog(0, "three")},
+			},
+			expectErr:      []string{"ABC-8", "ABC-9"},
+			expectWorklogs: map[string]int{"ABC-1": 0},
+		},
+		"blocked status category": {
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one")},
+				"XYZ-3": {worklog(0, "two")},
+			},
+			opts: client.UploadOptions{
+				StatusCategories: &config.StatusCategories{Block: []string{"done"}},
+			},
+			expectErr:      []string{"XYZ-3"},
+			expectWorklogs: map[string]int{"ABC-1": 0, "XYZ-3": 0},
+		},
+		"no permission": {
+			setup: func(f *jiratest.Fake) { f.NoPermission = []string{"XYZ"} },
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one")},
+				"XYZ-3": {worklog(0, "two")},
+			},
+			expectErr:      []string{"WORK_ON_ISSUES", "XYZ-3"},
+			expectWorklogs: map[string]int{"ABC-1": 0, "XYZ-3": 0},
+		},
+		"time tracking disabled": {
+			setup: func(f *jiratest.Fake) { f.TimeTrackingDisabled = true },
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one")},
+			},
+			expectErr:      []string{"time tracking is disabled"},
+			expectWorklogs: map[string]int{"ABC-1": 0},
+		},
+		"visibility": {
+			setup: func(f *jiratest.Fake) {
+				f.Groups = []string{"staff"}
+				f.Roles = map[string][]string{"ABC": {"Developers"}}
+			},
+			input: map[string][]parse.Worklog{
+				"ABC-1": {visible(worklog(0, "one"), config.Visibility{Group: "Staff"})},
+				"ABC-2": {visible(worklog(0, "two"), config.Visibility{Role: "developers"})},
+			},
+			expectResults:  2,
+			expectWorklogs: map[string]int{"ABC-1": 1, "ABC-2": 1},
+		},
+		"unknown visibility group and role": {
+			setup: func(f *jiratest.Fake) {
+				f.Roles = map[string][]string{"ABC": {"Developers"}}
+			},
+			input: map[string][]parse.Worklog{
+				"ABC-1": {visible(worklog(0, "one"), config.Visibility{Group: "staff"})},
+				"XYZ-3": {visible(worklog(0, "two"), config.Visibility{Role: "Developers"})},
+			},
+			expectErr: []string{"group staff doesn't exist",
+				"role Developers doesn't exist in project XYZ"},
+			expectWorklogs: map[string]int{"ABC-1": 0, "XYZ-3": 0},
+		},
+		"failure rolls back": {
+			setup: func(f *jiratest.Fake) {
+				f.AddWorklogErrors = map[string]error{"ABC-2": errors.New("boom")}
+			},
+			input: map[string][]parse.Worklog{
+				"ABC-1": {worklog(0, "one"), worklog(time.Hour, "two")},
+				"ABC-2": {worklog(30*time.Minute, "three")},
+			},
+			opts:           client.UploadOptions{Concurrency: 1},
+			expectErr:      []string{"ABC-2", "boom"},
+			expectResults:  3,
+			expectWorklogs: map[string]int{"ABC-1": 0, "ABC-2": 0},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			f := newFake()
+			if tc.setup != nil {
+				tc.setup(f)
+			}
+			results, err := client.UploadWorklogs(context.Background(), discardLogger, f,
+				tc.input, tc.opts)
+			if len(tc.expectErr) > 0 {
+				assert.Error(tt, err, "UploadWorklogs")
+				for _, s := range tc.expectErr {
+					assert.Contains(tt, err.Error(), s, "error message")
+				}
+			} else {
+				assert.NoError(tt, err, "UploadWorklogs")
+			}
+			assert.Equal(tt, tc.expectResults, len(results), "results")
+			for key, count := range tc.expectWorklogs {
+				assert.Equal(tt, count, len(f.Worklogs(key)), key)
+			}
+		})
+	}
+}
+
+// visible returns the worklog with the given visibility.
+func visible(worklog parse.Worklog, v config.Visibility) parse.Worklog {
+	worklog.Visibility = &v
+	return worklog
+}
+
+func TestUploadWorklogsVisibility(t *testing.T) {
+	f := newFake()
+	f.Roles = map[string][]string{"ABC": {"Developers"}}
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	input := map[string][]parse.Worklog{
+		"ABC-1": {
+			{Started: started, Duration: time.Hour},
+			visible(parse.Worklog{Started: started, Duration: time.Hour},
+				config.Visibility{Role: "Developers"}),
+		},
+	}
+	_, err := client.UploadWorklogs(context.Background(), discardLogger, f, input,
+		client.UploadOptions{Concurrency: 1})
+	assert.NoError(t, err, "UploadWorklogs")
+	wlrs := f.Worklogs("ABC-1")
+	assert.Equal(t, 2, len(wlrs), "worklogs")
+	assert.Zero(t, wlrs[0].Visibility, "first visibility")
+	assert.Equal(t, &client.WorklogVisibility{Type: "role", Value: "Developers"},
+		wlrs[1].Visibility, "second visibility")
+}
+
+func TestUploadWorklogsDuplicates(t *testing.T) {
+	f := newFake()
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	input := map[string][]parse.Worklog{
+		"ABC-1": {{Started: started, Duration: time.Hour, Comment: "one"}},
+	}
+	opts := client.UploadOptions{
+		Version:     "v1.2.3",
+		TimesheetID: "abc123",
+		AccountID:   f.CurrentUser.AccountID,
+	}
+	_, err := client.UploadWorklogs(context.Background(), discardLogger, f, input, opts)
+	assert.NoError(t, err, "first UploadWorklogs")
+	wlrs := f.Worklogs("ABC-1")
+	assert.Equal(t, 1, len(wlrs), "worklogs")
+	assert.Equal(t, &client.WorklogProperty{
+		Source:      "jiratime",
+		Version:     "v1.2.3",
+		Hash:        client.EntryHash("10001", input["ABC-1"][0]),
+		TimesheetID: "abc123",
+	}, client.GetWorklogProperty(&wlrs[0].WorklogRecord), "property")
+	// resubmitting the same entry and a new entry skips the duplicate
+	input["ABC-1"] = append(input["ABC-1"],
+		parse.Worklog{Started: started, Duration: time.Hour, Comment: "two"})
+	results, err := client.UploadWorklogs(context.Background(), discardLogger, f, input, opts)
+	assert.NoError(t, err, "second UploadWorklogs")
+	assert.True(t, results[0].Duplicate, "first duplicate")
+	assert.False(t, results[1].Duplicate, "second duplicate")
+	assert.Equal(t, 2, len(f.Worklogs("ABC-1")), "worklogs")
+	// unless duplicates are allowed
+	opts.AllowDuplicates = true
+	_, err = client.UploadWorklogs(context.Background(), discardLogger, f, input, opts)
+	assert.NoError(t, err, "third UploadWorklogs")
+	assert.Equal(t, 4, len(f.Worklogs("ABC-1")), "worklogs")
+}
+
+func TestUploadWorklogsOtherAuthorDuplicates(t *testing.T) {
+	f := newFake()
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	input := map[string][]parse.Worklog{
+		"ABC-1": {{Started: started, Duration: time.Hour, Comment: "standup"}},
+	}
+	// a teammate submitted an identical entry
+	jt := jira.Time(started)
+	_, err := f.AddWorklog(context.Background(), "ABC-1", &client.WorklogRecord{
+		WorklogRecord: jira.WorklogRecord{
+			Author:           &jira.User{AccountID: "teammate"},
+			Started:          &jt,
+			TimeSpentSeconds: 3600,
+			Comment:          "standup",
+			Properties: []jira.EntityProperty{{
+				Key: client.PropertyKey,
+				Value: client.WorklogProperty{
+					Source: "jiratime",
+					Hash:   client.EntryHash("10001", input["ABC-1"][0]),
+				},
+			}},
+		},
+	})
+	assert.NoError(t, err, "add teammate worklog")
+	results, err := client.UploadWorklogs(context.Background(), discardLogger, f,
+		input, client.UploadOptions{AccountID: f.CurrentUser.AccountID})
+	assert.NoError(t, err, "UploadWorklogs")
+	assert.False(t, results[0].Duplicate, "duplicate")
+	assert.Equal(t, 2, len(f.Worklogs("ABC-1")), "worklogs")
+}
+
+func TestUploadWorklogsResults(t *testing.T) {
+	f := newFake()
+	f.AddWorklogErrors = map[string]error{"ABC-2": errors.New("boom")}
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	input := map[string][]parse.Worklog{
+		"ABC-1": {{Started: started, Duration: time.Hour, Comment: "one"}},
+		"ABC-2": {{Started: started, Duration: time.Hour, Comment: "two"}},
+		"XYZ-3": {{Started: started, Duration: time.Hour, Comment: "three"}},
+	}
+	results, err := client.UploadWorklogs(context.Background(), discardLogger, f, input,
+		client.UploadOptions{Concurrency: 1})
+	assert.Error(t, err, "UploadWorklogs")
+	// results are sorted by issue key, and processing stops at the first error
+	assert.Equal(t, 3, len(results), "results")
+	assert.Equal(t, "ABC-1", results[0].Issue, "first issue")
+	assert.NoError(t, results[0].Err, "first error")
+	assert.True(t, results[0].RolledBack, "first rolled back")
+	assert.Equal(t, "admin", results[0].Metadata.Summary, "first summary")
+	assert.Equal(t, "ABC-2", results[1].Issue, "second issue")
+	assert.EqualError(t, results[1].Err, "boom", "second error")
+	assert.Equal(t, "XYZ-3", results[2].Issue, "third issue")
+	assert.IsError(t, results[2].Err, client.ErrNotAttempted, "third error")
+}
+
+// blockingWriter is a WorklogWriter whose AddWorklog calls block until
+// release is closed. It records the maximum number of concurrent calls.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	// fail is the comment of the worklog which fails without blocking.
+	fail string
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+	deleted   []string
+}
+
+// newBlockingWriter returns a blockingWriter for up to n worklogs.
+func newBlockingWriter(n int) *blockingWriter {
+	return &blockingWriter{
+		started: make(chan struct{}, n),
+		release: make(chan struct{}),
+	}
+}
+
+// AddWorklog implements the client.WorklogWriter interface.
+func (w *blockingWriter) AddWorklog(_ context.Context, _ string,
+	_ client.IssueMetadata, worklog parse.Worklog) (string, error) {
+	w.mu.Lock()
+	w.active++
+	w.maxActive = max(w.maxActive, w.active)
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.active--
+		w.mu.Unlock()
+	}()
+	w.started <- struct{}{}
+	if worklog.Comment == w.fail {
+		return "", errors.New("boom")
+	}
+	<-w.release
+	return "id-" + worklog.Comment, nil
+}
+
+// DeleteWorklog implements the client.WorklogWriter interface.
+func (w *blockingWriter) DeleteWorklog(_ context.Context,
+	_ client.IssueMetadata, id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deleted = append(w.deleted, id)
+	return nil
+}
+
+// SupportsVisibility implements the client.WorklogWriter interface.
+func (*blockingWriter) SupportsVisibility() bool { return true }
+
+// blockingInput returns n worklogs on ABC-1, with their index as comment.
+func blockingInput(n int) map[string][]parse.Worklog {
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	var worklogs []parse.Worklog
+	for i := range n {
+		worklogs = append(worklogs, parse.Worklog{
+			Started:  started.Add(time.Duration(i) * time.Hour),
+			Duration: time.Hour,
+			Comment:  strconv.Itoa(i),
+		})
+	}
+	return map[string][]parse.Worklog{"ABC-1": worklogs}
+}
+
+func TestUploadWorklogsConcurrency(t *testing.T) {
+	const n, concurrency = 10, 3
+	w := newBlockingWriter(n)
+	done := make(chan struct{})
+	var results []client.UploadResult
+	var err error
+	go func() {
+		defer close(done)
+		results, err = client.UploadWorklogs(context.Background(), discardLogger,
+			newFake(), blockingInput(n),
+			client.UploadOptions{Concurrency: concurrency, Writer: w})
+	}()
+	// the pool fills up before any call returns
+	for range concurrency {
+		<-w.started
+	}
+	close(w.release)
+	<-done
+	assert.NoError(t, err, "UploadWorklogs")
+	assert.Equal(t, concurrency, w.maxActive, "max concurrent calls")
+	// results are in input order, whatever order the calls completed in
+	assert.Equal(t, n, len(results), "results")
+	for i, result := range results {
+		assert.Equal(t, strconv.Itoa(i), result.Worklog.Comment, "comment %d", i)
+		assert.Equal(t, "id-"+strconv.Itoa(i), result.ID, "ID %d", i)
+	}
+}
+
+func TestUploadWorklogsConcurrencyFailure(t *testing.T) {
+	const n, concurrency = 6, 2
+	w := newBlockingWriter(n)
+	w.fail = "1"
+	done := make(chan struct{})
+	var results []client.UploadResult
+	var err error
+	go func() {
+		defer close(done)
+		results, err = client.UploadWorklogs(context.Background(), discardLogger,
+			newFake(), blockingInput(n),
+			client.UploadOptions{Concurrency: concurrency, Writer: w})
+	}()
+	for range concurrency {
+		<-w.started
+	}
+	close(w.release)
+	<-done
+	assert.Error(t, err, "UploadWorklogs")
+	assert.True(t, w.maxActive <= concurrency, "max concurrent calls")
+	// the worklog in flight completes and is rolled back, and no more are
+	// started after the failure
+	assert.Equal(t, n, len(results), "results")
+	assert.NoError(t, results[0].Err, "first error")
+	assert.True(t, results[0].RolledBack, "first rolled back")
+	assert.EqualError(t, results[1].Err, "boom", "second error")
+	for _, result := range results[2:] {
+		assert.IsError(t, result.Err, client.ErrNotAttempted, "not attempted")
+	}
+	assert.Equal(t, []string{"id-0"}, w.deleted, "deleted")
+}
+
+func TestUploadWorklogsDayOffset(t *testing.T) {
+	f := newFake()
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	input := map[string][]parse.Worklog{
+		"ABC-1": {{Started: started, Duration: 90 * time.Minute, Comment: "one"}},
+	}
+	_, err := client.UploadWorklogs(context.Background(), discardLogger, f, input,
+		client.UploadOptions{DayOffset: -1})
+	assert.NoError(t, err, "UploadWorklogs")
+	wlrs := f.Worklogs("ABC-1")
+	assert.Equal(t, 1, len(wlrs), "worklogs")
+	assert.Equal(t, started.AddDate(0, 0, -1), time.Time(*wlrs[0].Started),
+		"started")
+	assert.Equal(t, 5400, wlrs[0].TimeSpentSeconds, "time spent")
+	assert.Equal(t, "one", wlrs[0].Comment, "comment")
+}
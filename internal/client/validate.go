@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira/v2/cloud"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
+)
+
+// workOnIssues is the Jira permission required to add worklogs to an issue.
+const workOnIssues = "WORK_ON_ISSUES"
+
+// issueMetadataFields are the issue fields requested to populate
+// IssueMetadata.
+var issueMetadataFields = []string{"summary", "status", "project"}
+
+// IssueMetadata contains descriptive information about a Jira issue.
+type IssueMetadata struct {
+	ID                string `json:"id"`
+	Key               string `json:"key"`
+	Summary           string `json:"summary"`
+	Status            string `json:"status"`
+	StatusCategory    string `json:"statusCategory"`
+	StatusCategoryKey string `json:"statusCategoryKey"`
+	Project           string `json:"project"`
+}
+
+// issueMetadata extracts the IssueMetadata from the given issue.
+func issueMetadata(issue *jira.Issue) IssueMetadata {
+	meta := IssueMetadata{ID: issue.ID, Key: issue.Key}
+	if issue.Fields == nil {
+		return meta
+	}
+	meta.Summary = issue.Fields.Summary
+	meta.Project = issue.Fields.Project.Key
+	if issue.Fields.Status != nil {
+		meta.Status = issue.Fields.Status.Name
+		meta.StatusCategory = issue.Fields.Status.StatusCategory.Name
+		meta.StatusCategoryKey = issue.Fields.Status.StatusCategory.Key
+	}
+	return meta
+}
+
+// keyInJQL returns a JQL query matching the given issue keys.
+func keyInJQL(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = strconv.Quote(key)
+	}
+	return fmt.Sprintf("key in (%s)", strings.Join(quoted, ", "))
+}
+
+// validateIssues checks that all the given issue keys refer to issues which
+// exist and are accessible, and returns their metadata keyed by the given
+// issue keys. All missing or inaccessible issues are reported in the returned
+// error.
+//
+// If an issue has been moved, the Key in its metadata is the canonical key,
+// which differs from the given key.
+func validateIssues(ctx context.Context, log *slog.Logger, j Jira,
+	keys []string) (map[string]IssueMetadata, error) {
+	keys = slices.Sorted(slices.Values(keys))
+	metadata := map[string]IssueMetadata{}
+	// an empty key list is invalid JQL
+	if len(keys) == 0 {
+		return metadata, nil
+	}
+	issues, err := j.SearchIssues(ctx, keyInJQL(keys), issueMetadataFields)
+	if err != nil {
+		// Jira rejects the whole query if any key doesn't exist, so fall back to
+		// looking up each issue individually.
+		log.WarnCtx(ctx, "couldn't validate issues with a single search",
+			slog.Any("error", err))
+	}
+	for _, issue := range issues {
+		for _, key := range keys {
+			if strings.EqualFold(key, issue.Key) {
+				metadata[key] = issueMetadata(&issue)
+			}
+		}
+	}
+	var errs []error
+	for _, key := range keys {
+		if _, ok := metadata[key]; ok {
+			continue
+		}
+		issue, err := j.GetIssue(ctx, key, issueMetadataFields)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't get Jira issue %s: %v", key, err))
+			continue
+		}
+		metadata[key] = issueMetadata(issue)
+	}
+	return metadata, errors.Join(errs...)
+}
+
+// moved returns true if the given issue key refers to an issue which has been
+// moved and now has a different key.
+func (m IssueMetadata) moved(key string) bool {
+	return m.Key != "" && !strings.EqualFold(m.Key, key)
+}
+
+// CanonicalIssueKeys looks up the given issue keys and returns a map of keys
+// which refer to moved issues to the new key of the issue.
+func CanonicalIssueKeys(ctx context.Context, log *slog.Logger, j Jira,
+	keys []string) (map[string]string, error) {
+	metadata, err := validateIssues(ctx, log, j, keys)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't validate issues: %w", err)
+	}
+	canonical := map[string]string{}
+	for key, meta := range metadata {
+		if meta.moved(key) {
+			canonical[key] = meta.Key
+		}
+	}
+	return canonical, nil
+}
+
+// checkIssues checks that worklogs can be added to all of the given issues:
+// time tracking must be enabled, the issues must be in an allowed status
+// category, and the user must have permission to work on the issues. All
+// problems are reported in the returned error.
+func checkIssues(ctx context.Context, j Jira,
+	metadata map[string]IssueMetadata,
+	statusCategories *config.StatusCategories) error {
+	enabled, err := j.TimeTrackingEnabled(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't check time tracking: %v", err)
+	}
+	if !enabled {
+		return errors.New("time tracking is disabled in Jira")
+	}
+	var errs []error
+	projects := map[string][]string{}
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		meta := metadata[key]
+		if !statusCategories.Allowed(meta.StatusCategoryKey, meta.StatusCategory) {
+			errs = append(errs, fmt.Errorf("issue %s is in blocked status category %q",
+				key, meta.StatusCategory))
+		}
+		projects[meta.Project] = append(projects[meta.Project], key)
+	}
+	for _, project := range slices.Sorted(maps.Keys(projects)) {
+		ok, err := j.HavePermission(ctx, project, workOnIssues)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"couldn't check permissions in project %s: %v", project, err))
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf(
+				"missing %s permission in project %s for issues %s",
+				workOnIssues, project, strings.Join(projects[project], ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkVisibility checks that the given writer supports restricting the
+// visibility of worklogs, and that the groups and project roles which the
+// given worklogs are restricted to exist.
+func checkVisibility(ctx context.Context, j Jira, w WorklogWriter,
+	results []UploadResult) error {
+	var errs []error
+	groups := map[string]bool{}
+	projectRoles := map[string]map[string]bool{}
+	for _, result := range results {
+		v := result.Worklog.Visibility
+		switch {
+		case v == nil:
+		case !w.SupportsVisibility():
+			errs = append(errs, fmt.Errorf(
+				"worklog on %s at %s: visibility is not supported by the backend",
+				result.Issue, result.Worklog.Started.Format("1504")))
+		case v.Group != "":
+			groups[v.Group] = true
+		default:
+			project := result.Metadata.Project
+			if projectRoles[project] == nil {
+				projectRoles[project] = map[string]bool{}
+			}
+			projectRoles[project][v.Role] = true
+		}
+	}
+	for _, group := range slices.Sorted(maps.Keys(groups)) {
+		ok, err := j.GroupExists(ctx, group)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't check group %s: %v", group, err))
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("group %s doesn't exist", group))
+		}
+	}
+	for _, project := range slices.Sorted(maps.Keys(projectRoles)) {
+		names, err := j.ProjectRoles(ctx, project)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't get roles of project %s: %v",
+				project, err))
+			continue
+		}
+		for _, role := range slices.Sorted(maps.Keys(projectRoles[project])) {
+			if !slices.ContainsFunc(names, func(name string) bool {
+				return strings.EqualFold(name, role)
+			}) {
+				errs = append(errs, fmt.Errorf("role %s doesn't exist in project %s",
+					role, project))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
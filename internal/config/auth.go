@@ -18,20 +18,36 @@ type Auth struct {
 
 // OAuth2 is a config entry containing oauth2 secrets
 type OAuth2 struct {
-	ClientID string        `json:"clientID"`
-	Secret   string        `json:"secret"`
-	Token    *oauth2.Token `json:"token"`
+	ClientID string `json:"clientID"`
+	Secret   string `json:"secret"`
+	// RedirectURL is the callback URL configured in the OAuth2 app. It must be
+	// a http URL on a loopback address. Defaults to
+	// http://localhost:8080/oauth/redirect.
+	RedirectURL string `json:"redirectURL,omitempty"`
+	// Scope is the space separated list of scopes granted to the token.
+	Scope string        `json:"scope,omitempty"`
+	Token *oauth2.Token `json:"token"`
 }
 
 // HasAuth returns true if the auth file exists.
 func HasAuth() bool {
-	path, err := xdg.SearchConfigFile(authPathSuffix)
+	return hasAuth(selectedProfile)
+}
+
+// hasAuth is HasAuth for the given profile.
+func hasAuth(profile string) bool {
+	path, err := xdg.SearchConfigFile(profilePath(profile, authPathSuffix))
 	return err == nil && path != ""
 }
 
 // ReadAuth the config file.
 func ReadAuth() (*OAuth2, error) {
-	path, err := xdg.ConfigFile(authPathSuffix)
+	return readAuth(selectedProfile)
+}
+
+// readAuth is ReadAuth for the given profile.
+func readAuth(profile string) (*OAuth2, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, authPathSuffix))
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get path to auth config file: %v", err)
 	}
@@ -48,7 +64,12 @@ func ReadAuth() (*OAuth2, error) {
 
 // WriteAuth persists the given Config to the given path.
 func WriteAuth(o *OAuth2) error {
-	path, err := xdg.ConfigFile(authPathSuffix)
+	return writeAuth(selectedProfile, o)
+}
+
+// writeAuth is WriteAuth for the given profile.
+func writeAuth(profile string, o *OAuth2) error {
+	path, err := xdg.ConfigFile(profilePath(profile, authPathSuffix))
 	if err != nil {
 		return fmt.Errorf("couldn't get path to auth config file: %v", err)
 	}
@@ -56,7 +77,7 @@ func WriteAuth(o *OAuth2) error {
 	if err != nil {
 		return fmt.Errorf("couldn't marshal config: %v", err)
 	}
-	if err = os.WriteFile(path, confBytes, 0600); err != nil {
+	if err = writeFile(path, confBytes, true); err != nil {
 		return fmt.Errorf("couldn't write config file: %v", err)
 	}
 	return nil
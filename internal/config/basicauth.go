@@ -19,13 +19,23 @@ type BasicAuth struct {
 
 // HasBasicAuth returns true if the basicauth file exists.
 func HasBasicAuth() bool {
-	path, err := xdg.SearchConfigFile(basicAuthPathSuffix)
+	return hasBasicAuth(selectedProfile)
+}
+
+// hasBasicAuth is HasBasicAuth for the given profile.
+func hasBasicAuth(profile string) bool {
+	path, err := xdg.SearchConfigFile(profilePath(profile, basicAuthPathSuffix))
 	return err == nil && path != ""
 }
 
 // ReadBasicAuth the config file.
 func ReadBasicAuth() (*BasicAuth, error) {
-	path, err := xdg.ConfigFile(basicAuthPathSuffix)
+	return readBasicAuth(selectedProfile)
+}
+
+// readBasicAuth is ReadBasicAuth for the given profile.
+func readBasicAuth(profile string) (*BasicAuth, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, basicAuthPathSuffix))
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get path to basicAuth config file: %v", err)
 	}
@@ -42,7 +52,7 @@ func ReadBasicAuth() (*BasicAuth, error) {
 
 // WriteBasicAuth persists the given Config to the given path.
 func WriteBasicAuth(a *BasicAuth) error {
-	path, err := xdg.ConfigFile(basicAuthPathSuffix)
+	path, err := xdg.ConfigFile(profilePath(selectedProfile, basicAuthPathSuffix))
 	if err != nil {
 		return fmt.Errorf("couldn't get path to basicAuth config file: %v", err)
 	}
@@ -50,7 +60,7 @@ func WriteBasicAuth(a *BasicAuth) error {
 	if err != nil {
 		return fmt.Errorf("couldn't marshal basicAuth config: %v", err)
 	}
-	if err = os.WriteFile(path, confBytes, 0600); err != nil {
+	if err = writeFile(path, confBytes, true); err != nil {
 		return fmt.Errorf("couldn't write basicAuth config file: %v", err)
 	}
 	return nil
@@ -4,6 +4,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/adrg/xdg"
 	"sigs.k8s.io/yaml"
@@ -11,24 +13,223 @@ import (
 
 const pathSuffix = "jiratime/config.yml"
 
+// Jira deployment types.
+const (
+	DeploymentCloud      = "cloud"
+	DeploymentDataCenter = "datacenter"
+)
+
+// Submission backends, which are also sink types.
+const (
+	BackendJira  = "jira"
+	BackendTempo = "tempo"
+)
+
+// Sink types which don't submit worklogs to a backend.
+const (
+	SinkLedger = "ledger"
+	SinkStdout = "stdout"
+)
+
+// Sink formats used by the ledger and stdout sinks.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Visibility restricts who can view a worklog to the members of a group or
+// a project role. Exactly one of Group or Role must be set.
+type Visibility struct {
+	Group string `json:"group,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// validate checks that exactly one of Group or Role is set.
+func (v *Visibility) validate() error {
+	if (v.Group == "") == (v.Role == "") {
+		return fmt.Errorf("exactly one of group or role must be set")
+	}
+	return nil
+}
+
+// VisibilityRule restricts the visibility of worklogs on issues with keys
+// matching any of the regexes.
+type VisibilityRule struct {
+	Regexes []Regexp `json:"regexes"`
+	Visibility
+}
+
+// Sink configures a destination for submitted worklogs.
+type Sink struct {
+	// Type is one of jira, tempo, ledger, or stdout.
+	Type string `json:"type"`
+	// Path is the ledger file which worklogs are appended to.
+	Path string `json:"path,omitempty"`
+	// Format is the format of ledger and stdout sinks: csv (the default) or
+	// json.
+	Format string `json:"format,omitempty"`
+}
+
+// Route submits worklogs on issues in matching Jira projects to the Jira
+// site of another profile.
+type Route struct {
+	// Profile is the name of the profile of the Jira site.
+	Profile string `json:"profile"`
+	// Projects matches the keys of the routed Jira projects.
+	Projects []Regexp `json:"projects"`
+}
+
+// validate checks the Route configuration.
+func (r *Route) validate() error {
+	if !validProfile.MatchString(r.Profile) {
+		return fmt.Errorf("invalid profile name: %s", r.Profile)
+	}
+	if len(r.Projects) == 0 {
+		return fmt.Errorf("route requires at least one project")
+	}
+	return nil
+}
+
 // Issue represents the list of known Jira issues.
 type Issue struct {
-	ID             string   `json:"id"`
-	Regexes        []Regexp `json:"regexes"`
-	DefaultComment string   `json:"defaultComment"`
+	ID             string      `json:"id"`
+	Regexes        []Regexp    `json:"regexes"`
+	DefaultComment string      `json:"defaultComment"`
+	Tempo          *TempoIssue `json:"tempo,omitempty"`
+}
+
+// TempoIssue contains the Tempo fields set on worklogs submitted to an issue
+// via the Tempo backend.
+type TempoIssue struct {
+	// Account is the key of the Tempo account.
+	Account string `json:"account,omitempty"`
+	// Attributes maps Tempo work attribute keys to values.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// NonBillable submits worklogs with zero billable seconds. By default all
+	// time spent is billable.
+	NonBillable bool `json:"nonBillable,omitempty"`
+}
+
+// StatusCategories restricts the Jira issue status categories which worklogs
+// may be submitted against. Categories may be given by key (new,
+// indeterminate, done) or by name (To Do, In Progress, Done).
+type StatusCategories struct {
+	// Allow is the list of allowed status categories. If empty, all status
+	// categories not explicitly blocked are allowed.
+	Allow []string `json:"allow,omitempty"`
+	// Block is the list of blocked status categories.
+	Block []string `json:"block,omitempty"`
+}
+
+// Allowed returns true if a worklog may be submitted against an issue in a
+// status category with the given key or name.
+func (s *StatusCategories) Allowed(key, name string) bool {
+	if s == nil {
+		return true
+	}
+	match := func(c string) bool {
+		return strings.EqualFold(c, key) || strings.EqualFold(c, name)
+	}
+	if slices.ContainsFunc(s.Block, match) {
+		return false
+	}
+	return len(s.Allow) == 0 || slices.ContainsFunc(s.Allow, match)
+}
+
+// validate checks the Sink configuration.
+func (s *Sink) validate() error {
+	switch s.Type {
+	case BackendJira, BackendTempo:
+		return nil
+	case SinkLedger:
+		if s.Path == "" {
+			return fmt.Errorf("ledger sink requires a path")
+		}
+	case SinkStdout:
+	default:
+		return fmt.Errorf("unknown type: %s", s.Type)
+	}
+	switch s.Format {
+	case "", FormatCSV, FormatJSON:
+	default:
+		return fmt.Errorf("unknown format: %s", s.Format)
+	}
+	return nil
 }
 
 // Config represents the structure of the config file.
 type Config struct {
-	JiraURL     string   `json:"jiraURL"`
-	Issues      []Issue  `json:"issues"`
-	Ignore      []Regexp `json:"ignore"`
-	RoundIssues []Regexp `json:"roundIssues"`
+	JiraURL string `json:"jiraURL"`
+	// Deployment is the type of Jira deployment: cloud (the default), or
+	// datacenter.
+	Deployment string `json:"deployment,omitempty"`
+	// Backend is where worklogs are submitted: jira (the default), or tempo.
+	// It is ignored if Sinks is set.
+	Backend string `json:"backend,omitempty"`
+	// Sinks is the list of destinations which worklogs are submitted to. If
+	// empty, worklogs are submitted to Backend.
+	Sinks []Sink `json:"sinks,omitempty"`
+	// Network configures HTTP connections.
+	Network *Network `json:"network,omitempty"`
+	// Credentials configures where credentials are read from.
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// Routes submits worklogs on matching issues to the Jira sites of other
+	// profiles.
+	Routes []Route `json:"routes,omitempty"`
+	// Visibility restricts the visibility of worklogs on matching issues.
+	Visibility       []VisibilityRule  `json:"visibility,omitempty"`
+	Issues           []Issue           `json:"issues"`
+	Ignore           []Regexp          `json:"ignore"`
+	RoundIssues      []Regexp          `json:"roundIssues"`
+	StatusCategories *StatusCategories `json:"statusCategories,omitempty"`
+
+	// profile is the profile which the config was read from.
+	profile string
+}
+
+// SubmitSinks returns the sinks which worklogs are submitted to.
+func (c *Config) SubmitSinks() []Sink {
+	if len(c.Sinks) > 0 {
+		return c.Sinks
+	}
+	if c.Backend == "" {
+		return []Sink{{Type: BackendJira}}
+	}
+	return []Sink{{Type: c.Backend}}
+}
+
+// Route returns the name of the profile whose Jira site worklogs on the
+// issue with the given key are submitted to, or the empty string for the site
+// of c. The first route matching the project key of the issue applies.
+func (c *Config) Route(issue string) string {
+	project := issue
+	if i := strings.LastIndex(issue, "-"); i > 0 {
+		project = issue[:i]
+	}
+	for _, r := range c.Routes {
+		for _, p := range r.Projects {
+			if p.MatchString(project) {
+				return r.Profile
+			}
+		}
+	}
+	return ""
+}
+
+// HasConfig returns true if the config file exists.
+func HasConfig() bool {
+	path, err := xdg.SearchConfigFile(profilePath(selectedProfile, pathSuffix))
+	return err == nil && path != ""
 }
 
 // Read the config file.
 func Read() (*Config, error) {
-	path, err := xdg.ConfigFile(pathSuffix)
+	return read(selectedProfile)
+}
+
+// read is Read for the given profile.
+func read(profile string) (*Config, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, pathSuffix))
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get path to config file: %v", err)
 	}
@@ -36,16 +237,51 @@ func Read() (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't read config file: %v", err)
 	}
-	var c Config
+	c := Config{profile: profile}
 	if err = yaml.Unmarshal(y, &c); err != nil {
 		return nil, fmt.Errorf("couldn't unmarshal config: %v", err)
 	}
+	switch c.Deployment {
+	case "", DeploymentCloud, DeploymentDataCenter:
+	default:
+		return nil, fmt.Errorf("invalid deployment: %s", c.Deployment)
+	}
+	switch c.Backend {
+	case "", BackendJira, BackendTempo:
+	default:
+		return nil, fmt.Errorf("invalid backend: %s", c.Backend)
+	}
+	for i, s := range c.Sinks {
+		if err = s.validate(); err != nil {
+			return nil, fmt.Errorf("invalid sink %d: %v", i, err)
+		}
+	}
+	if c.Network != nil {
+		if err = c.Network.validate(); err != nil {
+			return nil, fmt.Errorf("invalid network config: %v", err)
+		}
+	}
+	if c.Credentials != nil {
+		if err = c.Credentials.validate(); err != nil {
+			return nil, fmt.Errorf("invalid credentials config: %v", err)
+		}
+	}
+	for i, r := range c.Routes {
+		if err = r.validate(); err != nil {
+			return nil, fmt.Errorf("invalid route %d: %v", i, err)
+		}
+	}
+	for i, rule := range c.Visibility {
+		if err = rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid visibility rule %d: %v", i, err)
+		}
+	}
 	return &c, nil
 }
 
 // Write persists the given Config to the given path.
 func Write(c *Config) error {
-	path, err := xdg.ConfigFile(pathSuffix)
+	path, err := xdg.ConfigFile(profilePath(selectedProfile, pathSuffix))
 	if err != nil {
 		return fmt.Errorf("couldn't get path to config file: %v", err)
 	}
@@ -53,7 +289,7 @@ func Write(c *Config) error {
 	if err != nil {
 		return fmt.Errorf("couldn't marshal config: %v", err)
 	}
-	if err = os.WriteFile(path, confBytes, 0600); err != nil {
+	if err = writeFile(path, confBytes, false); err != nil {
 		return fmt.Errorf("couldn't write config file: %v", err)
 	}
 	return nil
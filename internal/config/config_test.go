@@ -0,0 +1,356 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/alecthomas/assert/v2"
+	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func TestStatusCategoriesAllowed(t *testing.T) {
+	var testCases = map[string]struct {
+		input  *config.StatusCategories
+		key    string
+		name   string
+		expect bool
+	}{
+		"nil": {
+			input:  nil,
+			key:    "done",
+			name:   "Done",
+			expect: true,
+		},
+		"blocked by key": {
+			input:  &config.StatusCategories{Block: []string{"done"}},
+			key:    "done",
+			name:   "Finished",
+			expect: false,
+		},
+		"blocked by name": {
+			input:  &config.StatusCategories{Block: []string{"Done"}},
+			key:    "done",
+			name:   "Done",
+			expect: false,
+		},
+		"not blocked": {
+			input:  &config.StatusCategories{Block: []string{"done"}},
+			key:    "indeterminate",
+			name:   "In Progress",
+			expect: true,
+		},
+		"allowed by name": {
+			input:  &config.StatusCategories{Allow: []string{"in progress"}},
+			key:    "indeterminate",
+			name:   "In Progress",
+			expect: true,
+		},
+		"not in allow list": {
+			input:  &config.StatusCategories{Allow: []string{"indeterminate"}},
+			key:    "new",
+			name:   "To Do",
+			expect: false,
+		},
+		"block overrides allow": {
+			input: &config.StatusCategories{
+				Allow: []string{"done"},
+				Block: []string{"Done"},
+			},
+			key:    "done",
+			name:   "Done",
+			expect: false,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expect, tc.input.Allowed(tc.key, tc.name))
+		})
+	}
+}
+
+func TestRegexpRoundTrip(t *testing.T) {
+	var testCases = map[string]struct {
+		input  string
+		expect string
+	}{
+		"plain":     {input: `"^admin( .+)?$"`, expect: `^admin( .+)?$`},
+		"backslash": {input: `"^ticket \\d+$"`, expect: `^ticket \\d+$`},
+		"quotes":    {input: `"^\"quoted\"$"`, expect: `^\"quoted\"$`},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			var r config.Regexp
+			assert.NoError(tt, r.UnmarshalJSON([]byte(tc.input)), "unmarshal")
+			assert.Equal(tt, tc.expect, r.String(), "unmarshalled regexp")
+			out, err := r.MarshalJSON()
+			assert.NoError(tt, err, "marshal")
+			assert.Equal(tt, tc.input, string(out), "marshalled regexp")
+		})
+	}
+}
+
+func TestInsecureCredentialFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows doesn't have unix permission bits")
+	}
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(t.TempDir(), "config"))
+	xdg.Reload()
+	assert.NoError(t, config.WritePersonalAccessToken(
+		&config.PersonalAccessToken{Token: "token"}), "write pat")
+	assert.NoError(t, config.WriteTempo(&config.Tempo{Token: "token"}),
+		"write tempo")
+	assert.Equal(t, 0, len(config.InsecureCredentialFiles()), "secure")
+	path, err := xdg.ConfigFile("jiratime/pat.yml")
+	assert.NoError(t, err, "pat path")
+	assert.NoError(t, os.Chmod(path, 0644), "chmod")
+	assert.Equal(t, []string{path}, config.InsecureCredentialFiles(), "insecure")
+	// rewriting the file replaces it with a private one
+	assert.NoError(t, config.WritePersonalAccessToken(
+		&config.PersonalAccessToken{Token: "token"}), "rewrite pat")
+	assert.Equal(t, 0, len(config.InsecureCredentialFiles()), "rewritten")
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err, "read dir")
+	assert.Equal(t, 2, len(entries), "no temporary files")
+}
+
+func TestWriteSymlinkedConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows doesn't have unix permission bits")
+	}
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	xdg.Reload()
+	// a config file managed in a dotfiles repository
+	target := filepath.Join(dir, "dotfiles", "config.yml")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(target), 0755), "mkdir")
+	assert.NoError(t, os.WriteFile(target, []byte("jiraURL: old\n"), 0644),
+		"write target")
+	path, err := xdg.ConfigFile("jiratime/config.yml")
+	assert.NoError(t, err, "config path")
+	assert.NoError(t, os.Symlink(target, path), "symlink")
+	assert.NoError(t, config.Write(&config.Config{JiraURL: "new"}), "write")
+	fi, err := os.Lstat(path)
+	assert.NoError(t, err, "lstat")
+	assert.True(t, fi.Mode()&os.ModeSymlink != 0, "still a symlink")
+	fi, err = os.Stat(target)
+	assert.NoError(t, err, "stat")
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm(), "mode")
+	data, err := os.ReadFile(target)
+	assert.NoError(t, err, "read target")
+	assert.Contains(t, string(data), "jiraURL: new", "target")
+}
+
+func TestLockAuthTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows doesn't have flock")
+	}
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(t.TempDir(), "config"))
+	xdg.Reload()
+	unlock, err := config.LockAuth(context.Background())
+	assert.NoError(t, err, "lock")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = config.LockAuth(ctx)
+	assert.Error(t, err, "lock held by another")
+	assert.NoError(t, unlock(), "unlock")
+	unlock, err = config.LockAuth(context.Background())
+	assert.NoError(t, err, "lock after unlock")
+	assert.NoError(t, unlock(), "unlock")
+}
+
+func TestCredentialStoreBasicAuth(t *testing.T) {
+	var testCases = map[string]struct {
+		credentials *config.Credentials
+		env         map[string]string
+		expect      *config.BasicAuth
+		expectErr   bool
+	}{
+		"env": {
+			credentials: &config.Credentials{Store: "env", Scoped: true},
+			env: map[string]string{
+				"JIRATIME_USER":      "user@example.com",
+				"JIRATIME_API_TOKEN": "api-token",
+			},
+			expect: &config.BasicAuth{
+				User:   "user@example.com",
+				APIKey: "api-token",
+				Scoped: true,
+			},
+		},
+		"env missing": {
+			credentials: &config.Credentials{Store: "env"},
+			env:         map[string]string{"JIRATIME_USER": "user@example.com"},
+			expectErr:   true,
+		},
+		"helper": {
+			credentials: &config.Credentials{
+				Store: "helper",
+				Helper: `f() { test "$1" = get && grep -q '^kind=basicauth$' &&` +
+					` printf 'username=user@example.com\npassword=api-token\n'; }; f`,
+			},
+			expect: &config.BasicAuth{
+				User:   "user@example.com",
+				APIKey: "api-token",
+			},
+		},
+		"helper failure": {
+			credentials: &config.Credentials{Store: "helper", Helper: "false"},
+			expectErr:   true,
+		},
+		"helper timeout": {
+			credentials: &config.Credentials{
+				Store:         "helper",
+				Helper:        "f() { exec sleep 60; }; f",
+				HelperTimeout: &config.Duration{Duration: 100 * time.Millisecond},
+			},
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			if tc.credentials.Store == "helper" && runtime.GOOS == "windows" {
+				tt.Skip("credential helpers require sh")
+			}
+			for _, key := range []string{"JIRATIME_USER", "JIRATIME_API_TOKEN"} {
+				tt.Setenv(key, tc.env[key])
+			}
+			store := config.NewCredentialStore(tc.credentials,
+				"https://example.atlassian.net/")
+			assert.Equal(tt, !tc.expectErr, store.HasBasicAuth(), "has basic auth")
+			basic, err := store.ReadBasicAuth()
+			if tc.expectErr {
+				assert.Error(tt, err, "read basic auth")
+				return
+			}
+			assert.NoError(tt, err, "read basic auth")
+			assert.Equal(tt, tc.expect, basic, "basic auth")
+		})
+	}
+}
+
+func TestCredentialStoreOAuth2(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(t.TempDir(), "config"))
+	xdg.Reload()
+	t.Setenv("JIRATIME_OAUTH2_CLIENT_ID", "client-id")
+	t.Setenv("JIRATIME_OAUTH2_SECRET", "client-secret")
+	store := config.NewCredentialStore(&config.Credentials{Store: "env"}, "")
+	// there is no token before authorization
+	auth, err := store.ReadOAuth2()
+	assert.NoError(t, err, "read before authorization")
+	assert.Equal(t, &config.OAuth2{ClientID: "client-id", Secret: "client-secret"},
+		auth, "before authorization")
+	auth.Token = &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+	assert.NoError(t, store.WriteOAuth2(auth), "write")
+	// the client credentials are not written to auth.yml
+	file, err := config.ReadAuth()
+	assert.NoError(t, err, "read file")
+	assert.Equal(t, "", file.ClientID, "file client ID")
+	assert.Equal(t, "", file.Secret, "file secret")
+	auth, err = store.ReadOAuth2()
+	assert.NoError(t, err, "read after authorization")
+	assert.Equal(t, "client-secret", auth.Secret, "secret")
+	assert.Equal(t, "refresh", auth.Token.RefreshToken, "refresh token")
+}
+
+func TestSetProfile(t *testing.T) {
+	var testCases = map[string]struct {
+		name      string
+		expect    string
+		expectErr bool
+	}{
+		"empty":     {name: "", expect: "default"},
+		"default":   {name: "default", expect: "default"},
+		"named":     {name: "client-a.prod", expect: "client-a.prod"},
+		"traversal": {name: "../client-a", expectErr: true},
+		"separator": {name: "client/a", expectErr: true},
+		"hidden":    {name: ".client-a", expectErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			tt.Cleanup(func() { _ = config.SetProfile("") })
+			err := config.SetProfile(tc.name)
+			if tc.expectErr {
+				assert.Error(tt, err, "set profile")
+				return
+			}
+			assert.NoError(tt, err, "set profile")
+			assert.Equal(tt, tc.expect, config.Profile(), "profile")
+		})
+	}
+}
+
+func TestProfileFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config")
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	xdg.Reload()
+	t.Cleanup(func() { _ = config.SetProfile("") })
+	assert.NoError(t, config.SetProfile("client-a"), "set profile")
+	assert.NoError(t, config.WriteBasicAuth(&config.BasicAuth{User: "a"}),
+		"write profile basic auth")
+	_, err := os.Stat(filepath.Join(dir, "jiratime", "profiles", "client-a",
+		"basicauth.yml"))
+	assert.NoError(t, err, "profile basic auth")
+	// the default profile doesn't see the files of other profiles
+	assert.NoError(t, config.SetProfile(""), "set default profile")
+	assert.False(t, config.HasBasicAuth(), "default basic auth")
+}
+
+func TestRoute(t *testing.T) {
+	var testCases = map[string]struct {
+		routes    string
+		expect    map[string]string
+		expectErr bool
+	}{
+		"no routes": {
+			expect: map[string]string{"OPS-1": ""},
+		},
+		"first match": {
+			routes: "routes:\n" +
+				"- profile: client-a\n  projects: ['^OPS$', '^ADM']\n" +
+				"- profile: client-b\n  projects: ['^ADM']\n",
+			expect: map[string]string{
+				"OPS-1":     "client-a",
+				"ADMIN-2":   "client-a",
+				"DEVOPS-3":  "",
+				"ABC-4":     "",
+				"OPS-ABC-5": "",
+			},
+		},
+		"invalid profile": {
+			routes:    "routes:\n- profile: ../client-a\n  projects: ['^OPS$']\n",
+			expectErr: true,
+		},
+		"no projects": {
+			routes:    "routes:\n- profile: client-a\n",
+			expectErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			dir := filepath.Join(tt.TempDir(), "config")
+			tt.Setenv("XDG_CONFIG_HOME", dir)
+			xdg.Reload()
+			assert.NoError(tt, os.MkdirAll(filepath.Join(dir, "jiratime"), 0700),
+				"create config dir")
+			assert.NoError(tt, os.WriteFile(
+				filepath.Join(dir, "jiratime", "config.yml"),
+				[]byte("jiraURL: https://example.atlassian.net\n"+tc.routes), 0600),
+				"write config")
+			c, err := config.Read()
+			if tc.expectErr {
+				assert.Error(tt, err, "read config")
+				return
+			}
+			assert.NoError(tt, err, "read config")
+			for issue, profile := range tc.expect {
+				assert.Equal(tt, profile, c.Route(issue), issue)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+	"sigs.k8s.io/yaml"
+)
+
+// Credential store types.
+const (
+	CredentialStoreFile   = "file"
+	CredentialStoreEnv    = "env"
+	CredentialStoreHelper = "helper"
+)
+
+// Environment variables read by the env credential store.
+const (
+	EnvUser           = "JIRATIME_USER"
+	EnvAPIToken       = "JIRATIME_API_TOKEN"
+	EnvOAuth2ClientID = "JIRATIME_OAUTH2_CLIENT_ID"
+	EnvOAuth2Secret   = "JIRATIME_OAUTH2_SECRET"
+)
+
+// defaultHelperTimeout is the default maximum duration of a credential
+// helper command. It allows time for the helper to prompt the user, for
+// example to unlock a password store.
+const defaultHelperTimeout = time.Minute
+
+// Credentials configures where the basic auth credentials and OAuth2 client
+// credentials are read from.
+type Credentials struct {
+	// Store is one of file (the default), env, or helper.
+	Store string `json:"store,omitempty"`
+	// Helper is the shell command run by the helper store.
+	Helper string `json:"helper,omitempty"`
+	// HelperTimeout is the maximum duration of the helper command. Default
+	// 1m.
+	HelperTimeout *Duration `json:"helperTimeout,omitempty"`
+	// Scoped is true if the API token given by the env or helper store is a
+	// scoped token. The file store reads this from basicauth.yml instead.
+	Scoped bool `json:"scoped,omitempty"`
+}
+
+// validate returns an error if the Credentials are invalid.
+func (c *Credentials) validate() error {
+	switch c.Store {
+	case "", CredentialStoreFile, CredentialStoreEnv:
+	case CredentialStoreHelper:
+		if c.Helper == "" {
+			return fmt.Errorf("helper store requires a helper command")
+		}
+		if c.HelperTimeout != nil && c.HelperTimeout.Duration <= 0 {
+			return fmt.Errorf("helperTimeout must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown store: %s", c.Store)
+	}
+	return nil
+}
+
+// CredentialStore provides the secrets used to authenticate to Jira Cloud.
+// OAuth2 tokens are always stored in auth.yml, since they are rotated on
+// every refresh.
+type CredentialStore interface {
+	// HasBasicAuth returns true if the store has basic auth credentials.
+	HasBasicAuth() bool
+	// ReadBasicAuth returns the basic auth credentials.
+	ReadBasicAuth() (*BasicAuth, error)
+	// ReadOAuth2 returns the OAuth2 client credentials, along with the token
+	// in auth.yml, if any.
+	ReadOAuth2() (*OAuth2, error)
+	// WriteOAuth2 persists the given OAuth2 token and settings to auth.yml.
+	WriteOAuth2(*OAuth2) error
+	// LockOAuth2 takes an exclusive advisory lock which serializes reading,
+	// refreshing and writing the OAuth2 token across processes. It waits
+	// until the lock is acquired or ctx is done, and returns a function which
+	// releases the lock.
+	LockOAuth2(ctx context.Context) (func() error, error)
+}
+
+// NewCredentialStore returns the CredentialStore of the selected profile
+// configured by c, which may be nil. jiraURL is passed to the helper store.
+func NewCredentialStore(c *Credentials, jiraURL string) CredentialStore {
+	return newCredentialStore(selectedProfile, c, jiraURL)
+}
+
+// newCredentialStore is NewCredentialStore for the given profile.
+func newCredentialStore(
+	profile string,
+	c *Credentials,
+	jiraURL string,
+) CredentialStore {
+	if c == nil {
+		return fileStore{profile: profile}
+	}
+	switch c.Store {
+	case CredentialStoreEnv:
+		return &externalStore{profile: profile, scoped: c.Scoped, get: getEnv}
+	case CredentialStoreHelper:
+		timeout := defaultHelperTimeout
+		if c.HelperTimeout != nil {
+			timeout = c.HelperTimeout.Duration
+		}
+		return &externalStore{
+			profile: profile,
+			scoped:  c.Scoped,
+			get: func(kind string) (map[string]string, error) {
+				return runHelper(c.Helper, timeout, kind, jiraURL)
+			},
+		}
+	default:
+		return fileStore{profile: profile}
+	}
+}
+
+// CredentialStore returns the CredentialStore configured in c, for the
+// profile which c was read from.
+func (c *Config) CredentialStore() CredentialStore {
+	return newCredentialStore(c.profile, c.Credentials, c.JiraURL)
+}
+
+// fileStore implements CredentialStore using the basicauth.yml and auth.yml
+// of a profile.
+type fileStore struct {
+	profile string
+}
+
+// HasBasicAuth implements CredentialStore.
+func (s fileStore) HasBasicAuth() bool {
+	return hasBasicAuth(s.profile)
+}
+
+// ReadBasicAuth implements CredentialStore.
+func (s fileStore) ReadBasicAuth() (*BasicAuth, error) {
+	return readBasicAuth(s.profile)
+}
+
+// ReadOAuth2 implements CredentialStore.
+func (s fileStore) ReadOAuth2() (*OAuth2, error) {
+	return readAuth(s.profile)
+}
+
+// WriteOAuth2 implements CredentialStore.
+func (s fileStore) WriteOAuth2(o *OAuth2) error {
+	return writeAuth(s.profile, o)
+}
+
+// LockOAuth2 implements CredentialStore.
+func (s fileStore) LockOAuth2(ctx context.Context) (func() error, error) {
+	return lockAuth(ctx, s.profile)
+}
+
+// Credential kinds requested from an externalStore.
+const (
+	credentialKindBasicAuth = "basicauth"
+	credentialKindOAuth2    = "oauth2"
+)
+
+// externalStore implements CredentialStore using credentials from outside
+// jiratime's config files. The OAuth2 client credentials are never written
+// to auth.yml.
+type externalStore struct {
+	profile string
+	scoped  bool
+	// get returns the username and password of the given kind of credential.
+	get func(kind string) (map[string]string, error)
+
+	mu    sync.Mutex
+	cache map[string]map[string]string
+}
+
+// values returns the credentials of the given kind, calling get at most once
+// per kind so that a helper doesn't prompt the user repeatedly.
+func (s *externalStore) values(kind string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if values, ok := s.cache[kind]; ok {
+		return values, nil
+	}
+	values, err := s.get(kind)
+	if err != nil {
+		return nil, err
+	}
+	if s.cache == nil {
+		s.cache = map[string]map[string]string{}
+	}
+	s.cache[kind] = values
+	return values, nil
+}
+
+// HasBasicAuth implements CredentialStore.
+func (s *externalStore) HasBasicAuth() bool {
+	values, err := s.values(credentialKindBasicAuth)
+	return err == nil && values["username"] != "" && values["password"] != ""
+}
+
+// ReadBasicAuth implements CredentialStore.
+func (s *externalStore) ReadBasicAuth() (*BasicAuth, error) {
+	values, err := s.values(credentialKindBasicAuth)
+	if err != nil {
+		return nil, err
+	}
+	if values["username"] == "" || values["password"] == "" {
+		return nil, fmt.Errorf("missing basic auth username or password")
+	}
+	return &BasicAuth{
+		User:   values["username"],
+		APIKey: values["password"],
+		Scoped: s.scoped,
+	}, nil
+}
+
+// ReadOAuth2 implements CredentialStore.
+func (s *externalStore) ReadOAuth2() (*OAuth2, error) {
+	values, err := s.values(credentialKindOAuth2)
+	if err != nil {
+		return nil, err
+	}
+	if values["username"] == "" || values["password"] == "" {
+		return nil, fmt.Errorf("missing OAuth2 client ID or secret")
+	}
+	o, err := readAuthFile(s.profile)
+	if err != nil {
+		return nil, err
+	}
+	o.ClientID = values["username"]
+	o.Secret = values["password"]
+	return o, nil
+}
+
+// WriteOAuth2 implements CredentialStore.
+func (s *externalStore) WriteOAuth2(o *OAuth2) error {
+	withoutSecrets := *o
+	withoutSecrets.ClientID = ""
+	withoutSecrets.Secret = ""
+	return writeAuth(s.profile, &withoutSecrets)
+}
+
+// LockOAuth2 implements CredentialStore.
+func (s *externalStore) LockOAuth2(ctx context.Context) (func() error, error) {
+	return lockAuth(ctx, s.profile)
+}
+
+// readAuthFile returns the contents of the auth.yml of the given profile,
+// which may not exist.
+func readAuthFile(profile string) (*OAuth2, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, authPathSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get path to auth config file: %v", err)
+	}
+	y, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &OAuth2{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config file: %v", err)
+	}
+	var a Auth
+	if err = yaml.Unmarshal(y, &a); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal config: %v", err)
+	}
+	if a.OAuth2 == nil {
+		return &OAuth2{}, nil
+	}
+	return a.OAuth2, nil
+}
+
+// getEnv returns the credentials of the given kind from the environment.
+func getEnv(kind string) (map[string]string, error) {
+	switch kind {
+	case credentialKindBasicAuth:
+		return map[string]string{
+			"username": os.Getenv(EnvUser),
+			"password": os.Getenv(EnvAPIToken),
+		}, nil
+	case credentialKindOAuth2:
+		return map[string]string{
+			"username": os.Getenv(EnvOAuth2ClientID),
+			"password": os.Getenv(EnvOAuth2Secret),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown credential kind: %s", kind)
+	}
+}
+
+// runHelper runs the given helper command in the style of a git credential
+// helper. The command is run by the shell with the argument "get", and is
+// given the kind of credential and the Jira URL on stdin as key=value lines.
+// It must print the username and password as key=value lines to stdout. The
+// command is killed if it runs for longer than the given timeout.
+func runHelper(
+	helper string,
+	timeout time.Duration,
+	kind, jiraURL string,
+) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", helper+` "$@"`, "sh", "get")
+	cmd.Stdin = strings.NewReader(
+		fmt.Sprintf("kind=%s\nurl=%s\n\n", kind, jiraURL))
+	cmd.Stderr = os.Stderr
+	// don't wait for children of the shell which hold stdout open
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("credential helper timed out after %v", timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't run credential helper: %v", err)
+	}
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		values[key] = value
+	}
+	return values, nil
+}
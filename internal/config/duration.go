@@ -0,0 +1,31 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration is a type that supports JSON Unmarshalling from a duration string
+// such as "30s".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(text []byte) error {
+	var s string
+	if err := json.Unmarshal(text, &s); err != nil {
+		return err
+	}
+	dd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration{dd}
+	return nil
+}
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
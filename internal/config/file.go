@@ -0,0 +1,130 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/adrg/xdg"
+)
+
+// credentialPathSuffixes are the config files which contain credentials.
+var credentialPathSuffixes = []string{
+	authPathSuffix,
+	basicAuthPathSuffix,
+	patPathSuffix,
+	tempoPathSuffix,
+}
+
+// writeFile atomically replaces the file at path with the given data, so that
+// a concurrent reader never sees a partially written file. If path is a
+// symlink, the file it links to is replaced. Credential files and new files
+// are only readable by the current user, and other files keep their mode.
+func writeFile(path string, data []byte, credential bool) error {
+	target, err := filepath.EvalSymlinks(path)
+	switch {
+	case err == nil:
+		path = target
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("couldn't resolve symlinks: %v", err)
+	}
+	perm := os.FileMode(0600)
+	if !credential {
+		if fi, err := os.Stat(path); err == nil {
+			perm = fi.Mode().Perm()
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("couldn't create temporary file: %v", err)
+	}
+	tmp := f.Name()
+	// this is a no-op after a successful rename
+	defer os.Remove(tmp)
+	if err = f.Chmod(perm); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't set mode of temporary file: %v", err)
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't write temporary file: %v", err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("couldn't sync temporary file: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("couldn't close temporary file: %v", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("couldn't rename temporary file: %v", err)
+	}
+	return nil
+}
+
+// InsecureCredentialFiles returns the paths of any existing credential files
+// which are readable by users other than the owner.
+func InsecureCredentialFiles() []string {
+	// windows doesn't have unix permission bits
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	var insecure []string
+	for _, suffix := range credentialPathSuffixes {
+		path, err := xdg.SearchConfigFile(profilePath(selectedProfile, suffix))
+		if err != nil {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			insecure = append(insecure, path)
+		}
+	}
+	return insecure
+}
+
+// LockAuth takes an exclusive advisory lock which serializes reading,
+// refreshing and writing the OAuth2 token in auth.yml across processes. It
+// waits until the lock is acquired or ctx is done, and returns a function
+// which releases the lock.
+func LockAuth(ctx context.Context) (func() error, error) {
+	return lockAuth(ctx, selectedProfile)
+}
+
+// lockAuth is LockAuth for the given profile.
+func lockAuth(ctx context.Context, profile string) (func() error, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, authPathSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get path to auth config file: %v", err)
+	}
+	return lockFile(ctx, path+".lock")
+}
+
+// RemoveCredentials removes the credential files other than auth.yml, and
+// returns the paths of the files removed. auth.yml is kept since it contains
+// the OAuth2 client credentials, which identify jiratime rather than the
+// user.
+func RemoveCredentials() ([]string, error) {
+	var removed []string
+	for _, suffix := range credentialPathSuffixes {
+		if suffix == authPathSuffix {
+			continue
+		}
+		path, err := xdg.SearchConfigFile(profilePath(selectedProfile, suffix))
+		if err != nil {
+			continue
+		}
+		if err = os.Remove(path); err != nil {
+			return removed, fmt.Errorf("couldn't remove %s: %v", path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package config
+
+import "context"
+
+// lockFile is a no-op on platforms without flock. Atomic writes still ensure
+// that the file is never corrupted, but concurrent token refreshes may race.
+func lockFile(context.Context, string) (func() error, error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,45 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockRetryInterval is the interval between attempts to take a lock which is
+// held by another process.
+const lockRetryInterval = 50 * time.Millisecond
+
+// lockFile takes an exclusive flock on the file at path, creating it if
+// required. It retries until the lock is acquired or ctx is done, and returns
+// a function which releases the lock.
+func lockFile(ctx context.Context, path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open lock file: %v", err)
+	}
+	ticker := time.NewTicker(lockRetryInterval)
+	defer ticker.Stop()
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// closing the file releases the lock
+			return f.Close, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			_ = f.Close()
+			return nil, fmt.Errorf("couldn't lock file: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, fmt.Errorf("couldn't lock file: %v", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
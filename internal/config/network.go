@@ -0,0 +1,37 @@
+package config
+
+import "fmt"
+
+// Network configures the HTTP connections made by jiratime.
+type Network struct {
+	// ProxyURL is the URL of the HTTP(S) proxy used for all requests. If empty,
+	// the proxy is taken from the HTTPS_PROXY and NO_PROXY environment
+	// variables.
+	ProxyURL string `json:"proxyURL,omitempty"`
+	// CABundle is the path to a PEM file containing CA certificates which are
+	// trusted in addition to the system roots.
+	CABundle string `json:"caBundle,omitempty"`
+	// ClientCert and ClientKey are the paths to a PEM encoded client
+	// certificate and private key presented to servers requesting one.
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	// Timeout is the maximum duration of a single HTTP request. Default 30s.
+	Timeout *Duration `json:"timeout,omitempty"`
+	// ConnectTimeout is the maximum duration of establishing a connection,
+	// including the TLS handshake. Default 30s.
+	ConnectTimeout *Duration `json:"connectTimeout,omitempty"`
+}
+
+// validate returns an error if the Network is invalid.
+func (n *Network) validate() error {
+	if (n.ClientCert == "") != (n.ClientKey == "") {
+		return fmt.Errorf("clientCert and clientKey must be set together")
+	}
+	if n.Timeout != nil && n.Timeout.Duration <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if n.ConnectTimeout != nil && n.ConnectTimeout.Duration <= 0 {
+		return fmt.Errorf("connectTimeout must be positive")
+	}
+	return nil
+}
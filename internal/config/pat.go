@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/adrg/xdg"
+	"sigs.k8s.io/yaml"
+)
+
+const patPathSuffix = "jiratime/pat.yml"
+
+// PersonalAccessToken represents the structure of the pat.yml. Personal
+// access tokens are used to authenticate to Jira Data Center.
+type PersonalAccessToken struct {
+	Token string `json:"token"`
+}
+
+// HasPersonalAccessToken returns true if the pat file exists.
+func HasPersonalAccessToken() bool {
+	return hasPersonalAccessToken(selectedProfile)
+}
+
+// hasPersonalAccessToken is HasPersonalAccessToken for the given profile.
+func hasPersonalAccessToken(profile string) bool {
+	path, err := xdg.SearchConfigFile(profilePath(profile, patPathSuffix))
+	return err == nil && path != ""
+}
+
+// ReadPersonalAccessToken the config file.
+func ReadPersonalAccessToken() (*PersonalAccessToken, error) {
+	return readPersonalAccessToken(selectedProfile)
+}
+
+// readPersonalAccessToken is ReadPersonalAccessToken for the given profile.
+func readPersonalAccessToken(profile string) (*PersonalAccessToken, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, patPathSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get path to pat config file: %v", err)
+	}
+	y, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read pat config file: %v", err)
+	}
+	var p PersonalAccessToken
+	if err = yaml.Unmarshal(y, &p); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal pat config: %v", err)
+	}
+	return &p, nil
+}
+
+// WritePersonalAccessToken persists the given PersonalAccessToken.
+func WritePersonalAccessToken(p *PersonalAccessToken) error {
+	path, err := xdg.ConfigFile(profilePath(selectedProfile, patPathSuffix))
+	if err != nil {
+		return fmt.Errorf("couldn't get path to pat config file: %v", err)
+	}
+	confBytes, err := yaml.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal pat config: %v", err)
+	}
+	if err = writeFile(path, confBytes, true); err != nil {
+		return fmt.Errorf("couldn't write pat config file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultProfile is the name of the profile whose files are stored directly
+// in the jiratime config directory.
+const DefaultProfile = "default"
+
+// validProfile matches valid profile names, which are used as directory
+// names.
+var validProfile = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// selectedProfile is the name of the selected profile. The empty string
+// selects the default profile.
+var selectedProfile string
+
+// SetProfile selects the named profile. The files of a profile other than
+// the default are stored in the profiles/<name> subdirectory of the jiratime
+// config directory. An empty name selects the default profile.
+func SetProfile(name string) error {
+	name, err := profileDir(name)
+	if err != nil {
+		return err
+	}
+	selectedProfile = name
+	return nil
+}
+
+// profileDir returns the name of the directory of the named profile within
+// the profiles directory, or the empty string for the default profile.
+func profileDir(name string) (string, error) {
+	if name == DefaultProfile {
+		return "", nil
+	}
+	if name != "" && !validProfile.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name: %s", name)
+	}
+	return name, nil
+}
+
+// Profile returns the name of the selected profile.
+func Profile() string {
+	if selectedProfile == "" {
+		return DefaultProfile
+	}
+	return selectedProfile
+}
+
+// profilePath returns the given jiratime config path suffix within the
+// directory of the given profile.
+func profilePath(profile, suffix string) string {
+	if profile == "" {
+		return suffix
+	}
+	return path.Join("jiratime", "profiles", profile,
+		strings.TrimPrefix(suffix, "jiratime/"))
+}
+
+// ReadProfile reads the config file of the named profile.
+func ReadProfile(name string) (*Config, error) {
+	profile, err := profileDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return read(profile)
+}
+
+// HasAuth returns true if the auth file of the profile which c was read from
+// exists.
+func (c *Config) HasAuth() bool {
+	return hasAuth(c.profile)
+}
+
+// HasPersonalAccessToken returns true if the pat file of the profile which c
+// was read from exists.
+func (c *Config) HasPersonalAccessToken() bool {
+	return hasPersonalAccessToken(c.profile)
+}
+
+// ReadPersonalAccessToken reads the pat file of the profile which c was read
+// from.
+func (c *Config) ReadPersonalAccessToken() (*PersonalAccessToken, error) {
+	return readPersonalAccessToken(c.profile)
+}
+
+// ReadTempo reads the tempo file of the profile which c was read from.
+func (c *Config) ReadTempo() (*Tempo, error) {
+	return readTempo(c.profile)
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -10,7 +12,9 @@ type Regexp struct {
 	regexp.Regexp
 }
 
-// UnmarshalJSON satisfies the json.Unmarshaler interface.
+// UnmarshalJSON satisfies the json.Unmarshaler interface. The expression is
+// the JSON string with its escape sequences kept verbatim, so a YAML
+// double-quoted "\\d" or single-quoted '\d' is the expression \\d.
 func (r *Regexp) UnmarshalJSON(text []byte) error {
 	rr, err := regexp.Compile(strings.Trim(string(text), `"`))
 	if err != nil {
@@ -20,7 +24,12 @@ func (r *Regexp) UnmarshalJSON(text []byte) error {
 	return nil
 }
 
-// MarshalJSON satisfies the json.Marshaler interface.
+// MarshalJSON satisfies the json.Marshaler interface. It is the inverse of
+// UnmarshalJSON, so that rewriting the config doesn't change expressions.
 func (r *Regexp) MarshalJSON() ([]byte, error) {
-	return []byte(r.String()), nil
+	text := []byte(`"` + r.String() + `"`)
+	if !json.Valid(text) {
+		return nil, fmt.Errorf("couldn't marshal regexp: %s", r.String())
+	}
+	return text, nil
 }
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/adrg/xdg"
+	"sigs.k8s.io/yaml"
+)
+
+const tempoPathSuffix = "jiratime/tempo.yml"
+
+// Tempo represents the structure of the tempo.yml. The Tempo API token is
+// used to submit worklogs via the Tempo backend.
+type Tempo struct {
+	Token string `json:"token"`
+	// URL is the base URL of the Tempo API. If empty, the default global API
+	// URL is used.
+	URL string `json:"url,omitempty"`
+}
+
+// ReadTempo the config file.
+func ReadTempo() (*Tempo, error) {
+	return readTempo(selectedProfile)
+}
+
+// readTempo is ReadTempo for the given profile.
+func readTempo(profile string) (*Tempo, error) {
+	path, err := xdg.ConfigFile(profilePath(profile, tempoPathSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get path to tempo config file: %v", err)
+	}
+	y, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read tempo config file: %v", err)
+	}
+	var t Tempo
+	if err = yaml.Unmarshal(y, &t); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal tempo config: %v", err)
+	}
+	return &t, nil
+}
+
+// WriteTempo persists the given Tempo.
+func WriteTempo(t *Tempo) error {
+	path, err := xdg.ConfigFile(profilePath(selectedProfile, tempoPathSuffix))
+	if err != nil {
+		return fmt.Errorf("couldn't get path to tempo config file: %v", err)
+	}
+	confBytes, err := yaml.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("couldn't marshal tempo config: %v", err)
+	}
+	if err = writeFile(path, confBytes, true); err != nil {
+		return fmt.Errorf("couldn't write tempo config file: %v", err)
+	}
+	return nil
+}
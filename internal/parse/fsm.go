@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/smlx/fsm"
+	"github.com/smlx/jiratime/internal/config"
 )
 
 const (
@@ -42,6 +43,8 @@ type TimesheetParser struct {
 	defaultComment string
 	// issue is the Jira issue name e.g. XYZ-123
 	issue string
+	// visibility is the visibility override for the current entry
+	visibility *config.Visibility
 }
 
 // Occur handles an event occurrence.
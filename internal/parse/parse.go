@@ -5,22 +5,35 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/smlx/fsm"
 	"github.com/smlx/jiratime/internal/config"
+	"golang.org/x/exp/slog"
 )
 
 var timeRange = regexp.MustCompile(`^[0-9]{4}-[0-9]{4}\s?$`)
 var jiraIssue = regexp.MustCompile(`^([A-Za-z]+-[0-9]+)(\s.+)?$`)
+var visibility = regexp.MustCompile(`^\[(group|role):\s*(.+?)\s*\]$`)
 
 // Worklog represents an individual work log entry on a ticket.
 type Worklog struct {
 	Started  time.Time
 	Duration time.Duration
 	Comment  string // optional
+	// Visibility restricts who can view the worklog. Optional.
+	Visibility *config.Visibility
+}
+
+// Shift returns the worklog moved by the given number of days. The time of
+// day is kept across daylight saving time changes.
+func (w Worklog) Shift(days int) Worklog {
+	w.Started = w.Started.AddDate(0, 0, days)
+	return w
 }
 
 // parseTimeRange takes a string containing a time range in 24-hour notation,
@@ -74,12 +87,28 @@ func addWorklog(worklogs map[string][]Worklog, timesheet *TimesheetParser) {
 			append(timesheet.comment, timesheet.defaultComment)
 	}
 	worklogs[timesheet.issue] = append(worklogs[timesheet.issue], Worklog{
-		Started:  timesheet.started,
-		Duration: timesheet.duration,
-		Comment:  strings.Join(timesheet.comment, "\n"),
+		Started:    timesheet.started,
+		Duration:   timesheet.duration,
+		Comment:    strings.Join(timesheet.comment, "\n"),
+		Visibility: timesheet.visibility,
 	})
 }
 
+// addCommentLine adds the current line of the given TimesheetParser to the
+// comment, unless it is a visibility override such as "[group: staff]".
+func addCommentLine(timesheet *TimesheetParser) {
+	matches := visibility.FindStringSubmatch(timesheet.line)
+	switch {
+	case matches == nil:
+		timesheet.comment =
+			append(timesheet.comment, strings.Trim(timesheet.line, " -"))
+	case matches[1] == "group":
+		timesheet.visibility = &config.Visibility{Group: matches[2]}
+	default:
+		timesheet.visibility = &config.Visibility{Role: matches[2]}
+	}
+}
+
 // matchIgnore returns true if the line matches any of the ignore regexes, and
 // false otherwise.
 func matchIgnore(c *config.Config, line string) bool {
@@ -92,7 +121,11 @@ func matchIgnore(c *config.Config, line string) bool {
 }
 
 // Input parses text form stdin and returns an issue-Worklog map.
-func Input(r io.Reader, c *config.Config) (map[string][]Worklog, error) {
+func Input(
+	log *slog.Logger,
+	r io.Reader,
+	c *config.Config,
+) (map[string][]Worklog, error) {
 	var err error
 	worklogs := map[string][]Worklog{}
 	buf := bufio.NewReader(r)
@@ -116,6 +149,7 @@ func Input(r io.Reader, c *config.Config) (map[string][]Worklog, error) {
 				timesheet.comment = nil
 				timesheet.defaultComment = ""
 				timesheet.issue = ""
+				timesheet.visibility = nil
 				// parse the time range
 				timesheet.started, timesheet.duration, err =
 					parseTimeRange(timesheet.line)
@@ -125,8 +159,7 @@ func Input(r io.Reader, c *config.Config) (map[string][]Worklog, error) {
 		gotExplicitIssue: {
 			func(_ fsm.Event, src fsm.State) error {
 				if src == gotExplicitIssue {
-					timesheet.comment =
-						append(timesheet.comment, strings.Trim(timesheet.line, " -"))
+					addCommentLine(&timesheet)
 					return nil
 				}
 				// we have identified an explicit issue on the first line of an
@@ -144,8 +177,7 @@ func Input(r io.Reader, c *config.Config) (map[string][]Worklog, error) {
 		gotImplicitIssue: {
 			func(_ fsm.Event, src fsm.State) error {
 				if src == gotImplicitIssue {
-					timesheet.comment =
-						append(timesheet.comment, strings.Trim(timesheet.line, " -"))
+					addCommentLine(&timesheet)
 					return nil
 				}
 				// we haven't identified an issue yet, so try to do so here
@@ -185,6 +217,7 @@ func Input(r io.Reader, c *config.Config) (map[string][]Worklog, error) {
 				return nil, err
 			}
 		case matchIgnore(c, line):
+			log.Debug("ignoring line", slog.String("line", line))
 			if err = timesheet.Occur(ignore, line); err != nil {
 				return nil, err
 			}
@@ -197,5 +230,14 @@ func Input(r io.Reader, c *config.Config) (map[string][]Worklog, error) {
 	if err = timesheet.Machine.Occur(eof); err != nil {
 		return nil, err
 	}
+	for _, issue := range slices.Sorted(maps.Keys(worklogs)) {
+		var total time.Duration
+		for _, worklog := range worklogs[issue] {
+			total += worklog.Duration
+		}
+		log.Debug("parsed worklogs", slog.String("issue", issue),
+			slog.Int("worklogCount", len(worklogs[issue])),
+			slog.Duration("duration", total))
+	}
 	return worklogs, nil
 }
@@ -1,17 +1,22 @@
 package parse_test
 
 import (
+	"io"
 	"os"
 	"regexp"
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/alecthomas/assert"
 
 	"github.com/smlx/jiratime/internal/config"
 	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
 )
 
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard))
+
 type parseInput struct {
 	dataFile string
 	config   *config.Config
@@ -342,6 +347,48 @@ func TestParseInput(t *testing.T) {
 				},
 			},
 		},
+		"visibility overrides": {
+			input: &parseInput{
+				dataFile: "testdata/worklog4",
+				config: &config.Config{
+					Issues: []config.Issue{
+						{
+							ID:             "ADMIN-1",
+							DefaultComment: "email and stuff",
+							Regexes: wrapRegexes([]string{
+								"^admin$",
+							}),
+						},
+					},
+				},
+			},
+			expect: map[string][]parse.Worklog{
+				"ADMIN-1": {
+					{
+						Started: time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0,
+							0, now.Location()),
+						Duration:   1 * time.Hour,
+						Comment:    "email and stuff",
+						Visibility: &config.Visibility{Group: "staff-only"},
+					},
+					{
+						Started: time.Date(now.Year(), now.Month(), now.Day(), 10, 30, 0,
+							0, now.Location()),
+						Duration: 30 * time.Minute,
+						Comment:  "email and stuff",
+					},
+				},
+				"CUST-12": {
+					{
+						Started: time.Date(now.Year(), now.Month(), now.Day(), 10, 0, 0,
+							0, now.Location()),
+						Duration:   30 * time.Minute,
+						Comment:    "reviewed proposal\nsent feedback",
+						Visibility: &config.Visibility{Role: "Developers"},
+					},
+				},
+			},
+		},
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(tt *testing.T) {
@@ -349,7 +396,7 @@ func TestParseInput(t *testing.T) {
 			if err != nil {
 				tt.Fatal(err)
 			}
-			worklogs, err := parse.Input(f, tc.input.config)
+			worklogs, err := parse.Input(discardLogger, f, tc.input.config)
 			if err != nil {
 				tt.Fatal(err)
 			}
@@ -357,3 +404,22 @@ func TestParseInput(t *testing.T) {
 		})
 	}
 }
+
+func TestWorklogShift(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err, "LoadLocation")
+	// daylight saving time starts on 2024-03-31 in Berlin
+	worklog := parse.Worklog{Started: time.Date(2024, 4, 1, 9, 0, 0, 0, berlin)}
+	var testCases = map[string]struct {
+		days   int
+		expect time.Time
+	}{
+		"same day":   {days: 0, expect: time.Date(2024, 4, 1, 9, 0, 0, 0, berlin)},
+		"across DST": {days: -2, expect: time.Date(2024, 3, 30, 9, 0, 0, 0, berlin)},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expect, worklog.Shift(tc.days).Started)
+		})
+	}
+}
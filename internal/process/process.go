@@ -2,10 +2,12 @@
 package process
 
 import (
+	"slices"
 	"time"
 
 	"github.com/smlx/jiratime/internal/config"
 	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
 )
 
 // getRoundTime returns the duration which needs to be added to bring the slice
@@ -29,13 +31,15 @@ func getRoundTime(worklogs []parse.Worklog) time.Duration {
 // the total worklogs of matching issues rounded up to the next 15 minutes.
 //
 // This is done by adding a worklog entry for the issue to round out the total.
-func RoundWorklogs(worklogs map[string][]parse.Worklog,
+func RoundWorklogs(log *slog.Logger, worklogs map[string][]parse.Worklog,
 	roundIssues []config.Regexp) {
 	for issueKey := range worklogs {
 		for _, roundIssue := range roundIssues {
 			if roundIssue.MatchString(issueKey) {
 				roundTime := getRoundTime(worklogs[issueKey])
 				if roundTime > 0 {
+					log.Debug("rounding worklogs", slog.String("issue", issueKey),
+						slog.Duration("duration", roundTime))
 					// add a rounding issue to the issue worklogs
 					now := time.Now()
 					worklogs[issueKey] = append(worklogs[issueKey],
@@ -51,3 +55,28 @@ func RoundWorklogs(worklogs map[string][]parse.Worklog,
 		}
 	}
 }
+
+// RestrictVisibility sets the visibility of worklogs which don't already have
+// a visibility override from the timesheet. Each worklog gets the visibility
+// of the first rule with a regex matching its issue key.
+func RestrictVisibility(log *slog.Logger, worklogs map[string][]parse.Worklog,
+	rules []config.VisibilityRule) {
+	for issueKey := range worklogs {
+		for _, rule := range rules {
+			if !slices.ContainsFunc(rule.Regexes, func(r config.Regexp) bool {
+				return r.MatchString(issueKey)
+			}) {
+				continue
+			}
+			log.Debug("restricting worklog visibility", slog.String("issue", issueKey),
+				slog.String("group", rule.Group), slog.String("role", rule.Role))
+			for i := range worklogs[issueKey] {
+				if worklogs[issueKey][i].Visibility == nil {
+					v := rule.Visibility
+					worklogs[issueKey][i].Visibility = &v
+				}
+			}
+			break // go to the next issueKey
+		}
+	}
+}
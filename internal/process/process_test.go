@@ -1,6 +1,7 @@
 package process
 
 import (
+	"io"
 	"regexp"
 	"testing"
 	"time"
@@ -8,8 +9,11 @@ import (
 	"github.com/alecthomas/assert/v2"
 	"github.com/smlx/jiratime/internal/config"
 	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
 )
 
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard))
+
 func TestGetRoundTime(t *testing.T) {
 	var testCases = map[string]struct {
 		input  []parse.Worklog
@@ -570,8 +574,42 @@ func TestRoundWorklogs(t *testing.T) {
 	}
 	for name, tc := range testCases {
 		t.Run(name, func(tt *testing.T) {
-			RoundWorklogs(tc.input.worklogs, tc.input.roundIssues)
+			RoundWorklogs(discardLogger, tc.input.worklogs, tc.input.roundIssues)
 			assert.Equal(tt, tc.expect, tc.input.worklogs, "RoundWorklogs")
 		})
 	}
 }
+
+func TestRestrictVisibility(t *testing.T) {
+	staff := &config.Visibility{Group: "staff"}
+	devs := &config.Visibility{Role: "Developers"}
+	rules := []config.VisibilityRule{
+		{
+			Regexes:    []config.Regexp{{Regexp: *regexp.MustCompile("^CUST-")}},
+			Visibility: *staff,
+		},
+		{
+			Regexes:    []config.Regexp{{Regexp: *regexp.MustCompile("^CUST-1$")}},
+			Visibility: *devs,
+		},
+	}
+	worklogs := map[string][]parse.Worklog{
+		"CUST-1": {
+			{Duration: 20 * time.Minute},
+			{Duration: 20 * time.Minute, Visibility: devs},
+		},
+		"FOO-1": {
+			{Duration: 20 * time.Minute},
+		},
+	}
+	RestrictVisibility(discardLogger, worklogs, rules)
+	assert.Equal(t, map[string][]parse.Worklog{
+		"CUST-1": {
+			{Duration: 20 * time.Minute, Visibility: staff},
+			{Duration: 20 * time.Minute, Visibility: devs},
+		},
+		"FOO-1": {
+			{Duration: 20 * time.Minute},
+		},
+	}, worklogs, "RestrictVisibility")
+}
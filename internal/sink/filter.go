@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/smlx/jiratime/internal/parse"
+)
+
+// Filter is a Sink which passes only the worklogs on matching issues to
+// another Sink. If no issues match, the other Sink is not used at all.
+type Filter struct {
+	Sink
+	match func(issue string) bool
+	empty bool
+}
+
+// NewFilter returns a Filter which passes the worklogs on issues for which
+// match returns true to s.
+func NewFilter(s Sink, match func(issue string) bool) *Filter {
+	return &Filter{Sink: s, match: match}
+}
+
+// Unwrap returns the filtered Sink.
+func (s *Filter) Unwrap() Sink {
+	return s.Sink
+}
+
+// Validate implements the Sink interface.
+func (s *Filter) Validate(ctx context.Context,
+	issueWorklogs map[string][]parse.Worklog) error {
+	matched := map[string][]parse.Worklog{}
+	for issue, worklogs := range issueWorklogs {
+		if s.match(issue) {
+			matched[issue] = worklogs
+		}
+	}
+	s.empty = len(matched) == 0
+	if s.empty {
+		return nil
+	}
+	return s.Sink.Validate(ctx, matched)
+}
+
+// Write implements the Sink interface.
+func (s *Filter) Write(ctx context.Context) error {
+	if s.empty {
+		return nil
+	}
+	return s.Sink.Write(ctx)
+}
+
+// Rollback implements the Sink interface.
+func (s *Filter) Rollback(ctx context.Context) error {
+	if s.empty {
+		return nil
+	}
+	return s.Sink.Rollback(ctx)
+}
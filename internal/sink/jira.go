@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
+)
+
+// Jira is a Sink which adds worklogs to Jira issues using the client
+// package. The worklogs may be added to a different backend such as Tempo by
+// setting a Writer in the UploadOptions.
+type Jira struct {
+	name    string
+	log     *slog.Logger
+	j       client.Jira
+	opts    client.UploadOptions
+	results []client.UploadResult
+	written bool
+}
+
+// NewJira returns a Jira sink with the given name.
+func NewJira(
+	name string,
+	log *slog.Logger,
+	j client.Jira,
+	opts client.UploadOptions,
+) *Jira {
+	return &Jira{name: name, log: log, j: j, opts: opts}
+}
+
+// Name implements the Sink interface.
+func (s *Jira) Name() string {
+	return s.name
+}
+
+// Results returns the per-entry upload results, or nil if Write hasn't been
+// called.
+func (s *Jira) Results() []client.UploadResult {
+	if !s.written {
+		return nil
+	}
+	return s.results
+}
+
+// Validate implements the Sink interface.
+func (s *Jira) Validate(ctx context.Context,
+	issueWorklogs map[string][]parse.Worklog) error {
+	var err error
+	s.results, err = client.PrepareUpload(ctx, s.log, s.j, issueWorklogs, s.opts)
+	return err
+}
+
+// Write implements the Sink interface.
+func (s *Jira) Write(ctx context.Context) error {
+	s.written = true
+	return client.AddWorklogs(ctx, s.log, s.j, s.results, s.opts)
+}
+
+// Rollback implements the Sink interface.
+func (s *Jira) Rollback(ctx context.Context) error {
+	return client.RollbackWorklogs(ctx, s.log, s.j, s.results, s.opts)
+}
@@ -0,0 +1,171 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/smlx/jiratime/internal/config"
+	"github.com/smlx/jiratime/internal/parse"
+)
+
+// csvHeader is the header row of CSV output.
+var csvHeader = []string{"issue", "started", "seconds", "comment"}
+
+// encode writes the entries to w in the given format. CSV output begins with
+// a header row if header is true. JSON output is one object per line.
+func encode(w io.Writer, format string, es []Entry, header bool) error {
+	if format == config.FormatJSON {
+		enc := json.NewEncoder(w)
+		for _, e := range es {
+			if err := enc.Encode(e); err != nil {
+				return fmt.Errorf("couldn't encode entry: %v", err)
+			}
+		}
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	if header {
+		if err := cw.Write(csvHeader); err != nil {
+			return fmt.Errorf("couldn't write header: %v", err)
+		}
+	}
+	for _, e := range es {
+		err := cw.Write([]string{e.Issue, e.Started.Format(time.RFC3339),
+			strconv.Itoa(e.Seconds), e.Comment})
+		if err != nil {
+			return fmt.Errorf("couldn't write entry: %v", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Ledger is a Sink which appends worklogs to a local file.
+type Ledger struct {
+	path      string
+	format    string
+	dayOffset int
+	entries   []Entry
+	// size of the file before the last Write
+	size int64
+}
+
+// NewLedger returns a Ledger sink which appends to the file at the given
+// path in the given format. dayOffset is added to the worklog start times.
+func NewLedger(path, format string, dayOffset int) *Ledger {
+	return &Ledger{path: path, format: format, dayOffset: dayOffset}
+}
+
+// Name implements the Sink interface.
+func (s *Ledger) Name() string {
+	return "ledger " + s.path
+}
+
+// Validate implements the Sink interface. It checks that the ledger file can
+// be appended to, or created if it doesn't exist.
+func (s *Ledger) Validate(_ context.Context,
+	issueWorklogs map[string][]parse.Worklog) error {
+	s.entries = entries(issueWorklogs, s.dayOffset)
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err == nil {
+		return f.Close()
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("couldn't open ledger: %v", err)
+	}
+	info, err := os.Stat(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("couldn't stat ledger directory: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("ledger directory is not a directory")
+	}
+	return nil
+}
+
+// Write implements the Sink interface. The entries are appended to the
+// ledger in a single write.
+func (s *Ledger) Write(_ context.Context) error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("couldn't open ledger: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("couldn't stat ledger: %v", err)
+	}
+	s.size = info.Size()
+	var buf bytes.Buffer
+	if err = encode(&buf, s.format, s.entries, s.size == 0); err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err != nil {
+		// remove any partial write
+		_ = f.Truncate(s.size)
+		return fmt.Errorf("couldn't write ledger: %v", err)
+	}
+	return f.Close()
+}
+
+// Rollback implements the Sink interface. It truncates the ledger to its
+// size before the last Write.
+func (s *Ledger) Rollback(_ context.Context) error {
+	if err := os.Truncate(s.path, s.size); err != nil {
+		return fmt.Errorf("couldn't truncate ledger: %v", err)
+	}
+	return nil
+}
+
+// Stdout is a Sink which writes worklogs to an io.Writer, usually standard
+// output.
+type Stdout struct {
+	w         io.Writer
+	format    string
+	dayOffset int
+	entries   []Entry
+}
+
+// NewStdout returns a Stdout sink which writes to w in the given format.
+// dayOffset is added to the worklog start times.
+func NewStdout(w io.Writer, format string, dayOffset int) *Stdout {
+	return &Stdout{w: w, format: format, dayOffset: dayOffset}
+}
+
+// Name implements the Sink interface.
+func (s *Stdout) Name() string {
+	return "stdout"
+}
+
+// Validate implements the Sink interface.
+func (s *Stdout) Validate(_ context.Context,
+	issueWorklogs map[string][]parse.Worklog) error {
+	s.entries = entries(issueWorklogs, s.dayOffset)
+	return nil
+}
+
+// Write implements the Sink interface.
+func (s *Stdout) Write(_ context.Context) error {
+	var buf bytes.Buffer
+	if err := encode(&buf, s.format, s.entries, true); err != nil {
+		return err
+	}
+	_, err := s.w.Write(buf.Bytes())
+	return err
+}
+
+// Rollback implements the Sink interface. Output can't be unwritten, so this
+// does nothing.
+func (s *Stdout) Rollback(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package sink implements the destinations which worklogs are submitted to.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/smlx/jiratime/internal/parse"
+	"golang.org/x/exp/slog"
+)
+
+// Sink is a destination for submitted worklogs.
+type Sink interface {
+	// Name identifies the sink in messages.
+	Name() string
+	// Validate checks that the given worklogs can be written to the sink,
+	// without writing anything.
+	Validate(ctx context.Context, issueWorklogs map[string][]parse.Worklog) error
+	// Write writes the worklogs passed to the last call to Validate. If Write
+	// returns an error, nothing has been written.
+	Write(ctx context.Context) error
+	// Rollback undoes a successful call to Write as far as possible.
+	Rollback(ctx context.Context) error
+}
+
+// Submit validates the given worklogs against all the sinks, and then writes
+// them to each sink in turn. If validation fails for any sink, nothing is
+// written. If a write fails, the sinks already written to are rolled back.
+func Submit(
+	ctx context.Context,
+	log *slog.Logger,
+	sinks []Sink,
+	issueWorklogs map[string][]parse.Worklog,
+	dryRun bool,
+) error {
+	var errs []error
+	for _, s := range sinks {
+		if err := s.Validate(ctx, issueWorklogs); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	// exit early in dry-run mode
+	if dryRun {
+		log.InfoCtx(ctx, "dry-run mode: not submitting any work logs")
+		return nil
+	}
+	for i, s := range sinks {
+		err := s.Write(ctx)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		log.ErrorCtx(ctx, "couldn't write to sink: rolling back",
+			slog.String("sink", s.Name()), slog.Any("error", err))
+		for _, written := range slices.Backward(sinks[:i]) {
+			if err = written.Rollback(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("couldn't roll back %s: %w",
+					written.Name(), err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Entry is a single worklog entry as written by the ledger and stdout sinks.
+type Entry struct {
+	Issue   string    `json:"issue"`
+	Started time.Time `json:"started"`
+	Seconds int       `json:"seconds"`
+	Comment string    `json:"comment"`
+}
+
+// entries flattens the given issue-Worklog map into a slice of Entries
+// sorted by issue, and then in timesheet order. dayOffset is added to the
+// start times.
+func entries(issueWorklogs map[string][]parse.Worklog, dayOffset int) []Entry {
+	var es []Entry
+	for _, issue := range slices.Sorted(maps.Keys(issueWorklogs)) {
+		for _, worklog := range issueWorklogs[issue] {
+			es = append(es, Entry{
+				Issue:   issue,
+				Started: worklog.Shift(dayOffset).Started,
+				Seconds: int(worklog.Duration.Seconds()),
+				Comment: worklog.Comment,
+			})
+		}
+	}
+	return es
+}
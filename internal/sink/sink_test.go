@@ -0,0 +1,152 @@
+package sink_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/client/jiratest"
+	"github.com/smlx/jiratime/internal/config"
+	"github.com/smlx/jiratime/internal/parse"
+	"github.com/smlx/jiratime/internal/sink"
+	"golang.org/x/exp/slog"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard))
+
+// failSink is a Sink which fails validation or writing.
+type failSink struct {
+	validateErr error
+	writeErr    error
+}
+
+func (s *failSink) Name() string { return "fail" }
+
+func (s *failSink) Validate(context.Context, map[string][]parse.Worklog) error {
+	return s.validateErr
+}
+
+func (s *failSink) Write(context.Context) error { return s.writeErr }
+
+func (s *failSink) Rollback(context.Context) error { return nil }
+
+var started = time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+
+var input = map[string][]parse.Worklog{
+	"ABC-2": {{Started: started.Add(time.Hour), Duration: 30 * time.Minute}},
+	"ABC-1": {{Started: started, Duration: time.Hour, Comment: "one, two"}},
+}
+
+func TestSubmit(t *testing.T) {
+	var testCases = map[string]struct {
+		last           *failSink
+		dryRun         bool
+		expectErr      string
+		expectLedger   string
+		expectWorklogs int
+	}{
+		"success": {
+			last: &failSink{},
+			expectLedger: "issue,started,seconds,comment\n" +
+				"ABC-1,2024-01-02T09:00:00Z,3600,\"one, two\"\n" +
+				"ABC-2,2024-01-02T10:00:00Z,1800,\n",
+			expectWorklogs: 1,
+		},
+		"dry run": {
+			last:   &failSink{},
+			dryRun: true,
+		},
+		"validation failure": {
+			last:      &failSink{validateErr: errors.New("invalid")},
+			expectErr: "fail: invalid",
+		},
+		"write failure rolls back": {
+			last:      &failSink{writeErr: errors.New("boom")},
+			expectErr: "fail: boom",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			f := jiratest.New()
+			f.AddIssue("ABC-1", "admin", "indeterminate")
+			f.AddIssue("ABC-2", "on-call", "new")
+			path := filepath.Join(tt.TempDir(), "ledger.csv")
+			sinks := []sink.Sink{
+				sink.NewJira("jira", discardLogger, f, client.UploadOptions{}),
+				sink.NewLedger(path, config.FormatCSV, 0),
+				tc.last,
+			}
+			err := sink.Submit(context.Background(), discardLogger, sinks, input, tc.dryRun)
+			if tc.expectErr != "" {
+				assert.EqualError(tt, err, tc.expectErr, "Submit")
+			} else {
+				assert.NoError(tt, err, "Submit")
+			}
+			ledger, err := os.ReadFile(path)
+			if tc.expectLedger == "" && errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+			assert.NoError(tt, err, "read ledger")
+			assert.Equal(tt, tc.expectLedger, string(ledger), "ledger")
+			assert.Equal(tt, tc.expectWorklogs, len(f.Worklogs("ABC-1")), "worklogs")
+		})
+	}
+}
+
+func TestLedgerAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	for range 2 {
+		s := sink.NewLedger(path, config.FormatJSON, -1)
+		err := sink.Submit(context.Background(), discardLogger, []sink.Sink{s},
+			map[string][]parse.Worklog{"ABC-1": input["ABC-1"]}, false)
+		assert.NoError(t, err, "Submit")
+	}
+	ledger, err := os.ReadFile(path)
+	assert.NoError(t, err, "read ledger")
+	line := `{"issue":"ABC-1","started":"2024-01-01T09:00:00Z","seconds":3600,` +
+		`"comment":"one, two"}` + "\n"
+	assert.Equal(t, line+line, string(ledger), "ledger")
+}
+
+func TestStdout(t *testing.T) {
+	var buf bytes.Buffer
+	err := sink.Submit(context.Background(), discardLogger,
+		[]sink.Sink{sink.NewStdout(&buf, config.FormatCSV, 0)}, input, false)
+	assert.NoError(t, err, "Submit")
+	assert.Equal(t, "issue,started,seconds,comment\n"+
+		"ABC-1,2024-01-02T09:00:00Z,3600,\"one, two\"\n"+
+		"ABC-2,2024-01-02T10:00:00Z,1800,\n", buf.String(), "output")
+}
+
+func TestFilter(t *testing.T) {
+	var testCases = map[string]struct {
+		match  string
+		expect string
+	}{
+		"some match": {
+			match:  "ABC-2",
+			expect: "issue,started,seconds,comment\nABC-2,2024-01-02T10:00:00Z,1800,\n",
+		},
+		"none match": {
+			match: "DEF-1",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			var buf bytes.Buffer
+			s := sink.NewFilter(sink.NewStdout(&buf, config.FormatCSV, 0),
+				func(issue string) bool { return issue == tc.match })
+			err := sink.Submit(context.Background(), discardLogger,
+				[]sink.Sink{s}, input, false)
+			assert.NoError(tt, err, "Submit")
+			assert.Equal(tt, tc.expect, buf.String(), "output")
+		})
+	}
+}
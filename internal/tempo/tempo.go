@@ -0,0 +1,124 @@
+// Package tempo implements a Tempo Timesheets REST API client.
+package tempo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultURL is the base URL of the global Tempo REST API.
+const DefaultURL = "https://api.tempo.io/4/"
+
+// Attribute is a Tempo work attribute value.
+type Attribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Worklog is the request body used to create a Tempo worklog.
+type Worklog struct {
+	IssueID          int         `json:"issueId"`
+	AuthorAccountID  string      `json:"authorAccountId"`
+	StartDate        string      `json:"startDate"`
+	StartTime        string      `json:"startTime"`
+	TimeSpentSeconds int         `json:"timeSpentSeconds"`
+	BillableSeconds  int         `json:"billableSeconds"`
+	Description      string      `json:"description"`
+	Attributes       []Attribute `json:"attributes,omitempty"`
+}
+
+// Client is a Tempo REST API client.
+type Client struct {
+	httpClient *http.Client
+	baseURL    *url.URL
+}
+
+// NewClient returns a Client which sends requests to the Tempo API at the
+// given base URL using the given authenticated http.Client.
+func NewClient(httpClient *http.Client, baseURL string) (*Client, error) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse Tempo URL: %v", err)
+	}
+	return &Client{httpClient: httpClient, baseURL: u}, nil
+}
+
+// do sends a request to the given path relative to the base URL. If body is
+// not nil it is sent as JSON. If v is not nil the response body is decoded
+// into it.
+func (c *Client) do(ctx context.Context, method, path string, body,
+	v any) error {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("couldn't marshal request body: %v", err)
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method,
+		c.baseURL.JoinPath(path).String(), r)
+	if err != nil {
+		return fmt.Errorf("couldn't construct request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		apiErr := struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}{}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		var msgs []string
+		for _, e := range apiErr.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("bad response %d: %s", resp.StatusCode,
+			strings.Join(msgs, "; "))
+	}
+	if v == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("couldn't decode response: %v", err)
+	}
+	return nil
+}
+
+// CreateWorklog creates the given worklog and returns its Tempo ID.
+func (c *Client) CreateWorklog(ctx context.Context, wl *Worklog) (int, error) {
+	created := struct {
+		TempoWorklogID int `json:"tempoWorklogId"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "worklogs", wl, &created); err != nil {
+		return 0, fmt.Errorf("couldn't create worklog: %v", err)
+	}
+	return created.TempoWorklogID, nil
+}
+
+// DeleteWorklog deletes the worklog with the given Tempo ID.
+func (c *Client) DeleteWorklog(ctx context.Context, id int) error {
+	err := c.do(ctx, http.MethodDelete, "worklogs/"+strconv.Itoa(id), nil, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't delete worklog: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package tempo
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/config"
+	"github.com/smlx/jiratime/internal/parse"
+)
+
+// accountAttribute is the key of the Tempo work attribute containing the
+// account.
+const accountAttribute = "_Account_"
+
+// Writer implements the client.WorklogWriter interface by creating Tempo
+// worklogs.
+type Writer struct {
+	c         *Client
+	accountID string
+	issues    []config.Issue
+}
+
+// NewWriter returns a Writer which creates worklogs authored by the Jira user
+// with the given account ID. The Tempo fields of the worklogs are taken from
+// the given issues.
+func NewWriter(c *Client, accountID string, issues []config.Issue) *Writer {
+	return &Writer{c: c, accountID: accountID, issues: issues}
+}
+
+// tempoIssue returns the Tempo configuration of the given issue, or nil if
+// there is none.
+func (w *Writer) tempoIssue(keys ...string) *config.TempoIssue {
+	for _, issue := range w.issues {
+		if slices.ContainsFunc(keys, func(key string) bool {
+			return strings.EqualFold(issue.ID, key)
+		}) {
+			return issue.Tempo
+		}
+	}
+	return nil
+}
+
+// AddWorklog implements the client.WorklogWriter interface.
+func (w *Writer) AddWorklog(ctx context.Context, issue string,
+	meta client.IssueMetadata, worklog parse.Worklog) (string, error) {
+	issueID, err := strconv.Atoi(meta.ID)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse ID of issue %s: %v", meta.Key, err)
+	}
+	seconds := int(worklog.Duration.Seconds())
+	wl := Worklog{
+		IssueID:          issueID,
+		AuthorAccountID:  w.accountID,
+		StartDate:        worklog.Started.Format("2006-01-02"),
+		StartTime:        worklog.Started.Format("15:04:05"),
+		TimeSpentSeconds: seconds,
+		BillableSeconds:  seconds,
+		Description:      worklog.Comment,
+	}
+	if ti := w.tempoIssue(issue, meta.Key); ti != nil {
+		if ti.NonBillable {
+			wl.BillableSeconds = 0
+		}
+		if ti.Account != "" {
+			wl.Attributes = append(wl.Attributes,
+				Attribute{Key: accountAttribute, Value: ti.Account})
+		}
+		for _, key := range slices.Sorted(maps.Keys(ti.Attributes)) {
+			wl.Attributes = append(wl.Attributes,
+				Attribute{Key: key, Value: ti.Attributes[key]})
+		}
+	}
+	id, err := w.c.CreateWorklog(ctx, &wl)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(id), nil
+}
+
+// DeleteWorklog implements the client.WorklogWriter interface.
+func (w *Writer) DeleteWorklog(ctx context.Context, _ client.IssueMetadata,
+	id string) error {
+	tempoID, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("couldn't parse Tempo worklog ID %s: %v", id, err)
+	}
+	return w.c.DeleteWorklog(ctx, tempoID)
+}
+
+// SupportsVisibility implements the client.WorklogWriter interface. Tempo
+// worklogs are visible according to Tempo's own permissions.
+func (w *Writer) SupportsVisibility() bool {
+	return false
+}
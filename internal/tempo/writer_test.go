@@ -0,0 +1,166 @@
+package tempo_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/smlx/jiratime/internal/client"
+	"github.com/smlx/jiratime/internal/client/jiratest"
+	"github.com/smlx/jiratime/internal/config"
+	"github.com/smlx/jiratime/internal/parse"
+	"github.com/smlx/jiratime/internal/tempo"
+	"golang.org/x/exp/slog"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard))
+
+// fakeTempo is a fake Tempo worklogs API.
+type fakeTempo struct {
+	mu       sync.Mutex
+	worklogs map[int]tempo.Worklog
+	nextID   int
+	// failIssue is an issue ID for which worklog creation fails.
+	failIssue int
+}
+
+func (f *fakeTempo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/4/worklogs":
+		var wl tempo.Worklog
+		if err := json.NewDecoder(r.Body).Decode(&wl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if wl.IssueID == f.failIssue {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"account closed"}]}`))
+			return
+		}
+		f.nextID++
+		f.worklogs[f.nextID] = wl
+		_ = json.NewEncoder(w).Encode(map[string]int{"tempoWorklogId": f.nextID})
+	case r.Method == http.MethodDelete:
+		var id int
+		if _, err := fmt.Sscanf(r.URL.Path, "/4/worklogs/%d", &id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		delete(f.worklogs, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	issues := []config.Issue{{
+		ID: "ABC-1",
+		Tempo: &config.TempoIssue{
+			Account:    "ACC-1",
+			Attributes: map[string]string{"_Role_": "Dev"},
+		},
+	}, {
+		ID:    "ABC-2",
+		Tempo: &config.TempoIssue{NonBillable: true},
+	}}
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	input := map[string][]parse.Worklog{
+		"ABC-1": {{Started: started, Duration: time.Hour, Comment: "one"}},
+		"ABC-2": {{Started: started.Add(time.Hour), Duration: 30 * time.Minute}},
+	}
+	var testCases = map[string]struct {
+		failIssue      int
+		expectErr      bool
+		expectWorklogs map[int]tempo.Worklog
+	}{
+		"success": {
+			expectWorklogs: map[int]tempo.Worklog{
+				1: {
+					IssueID:          10001,
+					AuthorAccountID:  "account-id",
+					StartDate:        "2024-01-02",
+					StartTime:        "09:00:00",
+					TimeSpentSeconds: 3600,
+					BillableSeconds:  3600,
+					Description:      "one",
+					Attributes: []tempo.Attribute{
+						{Key: "_Account_", Value: "ACC-1"},
+						{Key: "_Role_", Value: "Dev"},
+					},
+				},
+				2: {
+					IssueID:          10002,
+					AuthorAccountID:  "account-id",
+					StartDate:        "2024-01-02",
+					StartTime:        "10:00:00",
+					TimeSpentSeconds: 1800,
+				},
+			},
+		},
+		"failure rolls back": {
+			failIssue:      10002,
+			expectErr:      true,
+			expectWorklogs: map[int]tempo.Worklog{},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			f := jiratest.New()
+			f.AddIssue("ABC-1", "admin", "indeterminate")
+			f.AddIssue("ABC-2", "on-call", "new")
+			ft := &fakeTempo{worklogs: map[int]tempo.Worklog{}, failIssue: tc.failIssue}
+			srv := httptest.NewServer(ft)
+			defer srv.Close()
+			tempoClient, err := tempo.NewClient(srv.Client(), srv.URL+"/4")
+			assert.NoError(tt, err, "NewClient")
+			_, err = client.UploadWorklogs(context.Background(), discardLogger, f, input,
+				client.UploadOptions{
+					Concurrency: 1,
+					Writer:      tempo.NewWriter(tempoClient, "account-id", issues),
+				})
+			if tc.expectErr {
+				assert.Error(tt, err, "UploadWorklogs")
+				assert.Contains(tt, err.Error(), "account closed", "error message")
+			} else {
+				assert.NoError(tt, err, "UploadWorklogs")
+			}
+			assert.Equal(tt, tc.expectWorklogs, ft.worklogs, "worklogs")
+			assert.Equal(tt, 0, len(f.Worklogs("ABC-1")), "jira worklogs")
+		})
+	}
+}
+
+func TestWriterVisibility(t *testing.T) {
+	f := jiratest.New()
+	f.AddIssue("ABC-1", "admin", "indeterminate")
+	f.Groups = []string{"staff"}
+	ft := &fakeTempo{worklogs: map[int]tempo.Worklog{}}
+	srv := httptest.NewServer(ft)
+	defer srv.Close()
+	tempoClient, err := tempo.NewClient(srv.Client(), srv.URL+"/4")
+	assert.NoError(t, err, "NewClient")
+	started := time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local)
+	// visibility is rejected before anything is written
+	_, err = client.PrepareUpload(context.Background(), discardLogger, f,
+		map[string][]parse.Worklog{"ABC-1": {{
+			Started:    started,
+			Duration:   time.Hour,
+			Visibility: &config.Visibility{Group: "staff"},
+		}}},
+		client.UploadOptions{
+			Writer: tempo.NewWriter(tempoClient, "account-id", nil),
+		})
+	assert.Error(t, err, "PrepareUpload")
+	assert.Contains(t, err.Error(), "visibility is not supported", "error message")
+	assert.Equal(t, 0, len(ft.worklogs), "worklogs")
+}
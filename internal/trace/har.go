@@ -0,0 +1,72 @@
+// Package trace implements recording and replay of HTTP requests and
+// responses, in a format based on HAR 1.2.
+package trace
+
+import "time"
+
+// HAR is the root object of a trace file.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log contains the recorded entries.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+// Creator identifies the application which recorded the trace.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a single request and its response.
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the duration of the request in milliseconds.
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	// Comment contains the error returned by the transport, if any.
+	Comment string `json:"comment,omitempty"`
+}
+
+// Request is a recorded HTTP request.
+type Request struct {
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	HTTPVersion string    `json:"httpVersion"`
+	Headers     []Header  `json:"headers"`
+	PostData    *PostData `json:"postData,omitempty"`
+}
+
+// Response is a recorded HTTP response. Status is zero if the request
+// failed.
+type Response struct {
+	Status      int      `json:"status"`
+	StatusText  string   `json:"statusText"`
+	HTTPVersion string   `json:"httpVersion"`
+	Headers     []Header `json:"headers"`
+	Content     Content  `json:"content"`
+}
+
+// Header is a single HTTP header value.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData is a recorded request body.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Content is a recorded response body.
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
@@ -0,0 +1,125 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Recorder records the requests and responses handled by the
+// http.RoundTrippers returned by Wrap in a single trace, with credentials
+// redacted.
+type Recorder struct {
+	creator Creator
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// NewRecorder returns an empty Recorder. The name and version identify the
+// application in the trace.
+func NewRecorder(name, version string) *Recorder {
+	return &Recorder{creator: Creator{Name: name, Version: version}}
+}
+
+// Wrap returns an http.RoundTripper which handles requests using the given
+// http.RoundTripper, and records them in r.
+func (r *Recorder) Wrap(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{r: r, next: next}
+}
+
+// recordingTransport implements the http.RoundTripper interface for a
+// Recorder.
+type recordingTransport struct {
+	r    *Recorder
+	next http.RoundTripper
+}
+
+// RoundTrip handles the request using the wrapped http.RoundTripper, and
+// records the request and response.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := Entry{
+		StartedDateTime: time.Now(),
+		Request: Request{
+			Method:      req.Method,
+			URL:         redactURL(req.URL),
+			HTTPVersion: req.Proto,
+			Headers:     redactHeaders(req.Header),
+		},
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read request body: %v", err)
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		mimeType := req.Header.Get("Content-Type")
+		entry.Request.PostData = &PostData{
+			MimeType: mimeType,
+			Text:     redactBody(mimeType, string(body)),
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	entry.Time = float64(time.Since(entry.StartedDateTime).Microseconds()) / 1000
+	if err != nil {
+		entry.Comment = err.Error()
+		entry.Response.Headers = []Header{}
+		t.r.add(entry)
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read response body: %v", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	mimeType := resp.Header.Get("Content-Type")
+	entry.Response = Response{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Headers:     redactHeaders(resp.Header),
+		Content: Content{
+			Size:     len(body),
+			MimeType: mimeType,
+			Text:     redactBody(mimeType, string(body)),
+		},
+	}
+	t.r.add(entry)
+	return resp, nil
+}
+
+// add appends the given entry to the trace.
+func (r *Recorder) add(entry Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// Write the recorded trace to the given io.Writer.
+func (r *Recorder) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	har := HAR{Log: Log{
+		Version: "1.2",
+		Creator: r.creator,
+		Entries: r.entries,
+	}}
+	if har.Log.Entries == nil {
+		har.Log.Entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(har); err != nil {
+		return fmt.Errorf("couldn't encode trace: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package trace
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redacted replaces sensitive values in recorded traces.
+const redacted = "REDACTED"
+
+// sensitiveHeaders are the canonical names of headers which carry
+// credentials.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveKeys are the lower case names of query parameters, form fields,
+// and JSON object keys which carry credentials.
+var sensitiveKeys = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"client_secret": true,
+	"code_verifier": true,
+	"code":          true,
+	"token":         true,
+	"apikey":        true,
+	"api_token":     true,
+	"password":      true,
+}
+
+// sensitiveJSON matches JSON string values of sensitive keys.
+var sensitiveJSON = regexp.MustCompile(
+	`(?i)("(?:access_token|refresh_token|id_token|client_secret|` +
+		`code_verifier|token|apikey|api_token|password)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// redactHeaders returns the given headers with credentials redacted, sorted
+// by name.
+func redactHeaders(h http.Header) []Header {
+	headers := []Header{}
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		for _, value := range h[name] {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				value = redacted
+			}
+			headers = append(headers, Header{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// redactValues redacts sensitive values in the given url.Values in place.
+func redactValues(v url.Values) {
+	for key := range v {
+		if sensitiveKeys[strings.ToLower(key)] {
+			for i := range v[key] {
+				v[key][i] = redacted
+			}
+		}
+	}
+}
+
+// redactURL returns the given URL with credentials redacted from the
+// userinfo and query.
+func redactURL(u *url.URL) string {
+	r := *u
+	if r.User != nil {
+		r.User = url.User(redacted)
+	}
+	if r.RawQuery != "" {
+		q := r.Query()
+		redactValues(q)
+		r.RawQuery = q.Encode()
+	}
+	return r.String()
+}
+
+// redactBody returns the given body text with credentials redacted,
+// according to its MIME type.
+func redactBody(mimeType, text string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "application/x-www-form-urlencoded"):
+		v, err := url.ParseQuery(text)
+		if err != nil {
+			return redacted
+		}
+		redactValues(v)
+		return v.Encode()
+	default:
+		return sensitiveJSON.ReplaceAllString(text, `$1"`+redacted+`"`)
+	}
+}
@@ -0,0 +1,124 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Replayer implements the http.RoundTripper interface. It responds to
+// requests with the responses recorded in a trace, without making any
+// connections.
+type Replayer struct {
+	mu      sync.Mutex
+	entries []Entry
+	used    []bool
+}
+
+// NewReplayer returns a Replayer which replays the trace read from the given
+// io.Reader.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	var har HAR
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("couldn't decode trace: %v", err)
+	}
+	return &Replayer{
+		entries: har.Log.Entries,
+		used:    make([]bool, len(har.Log.Entries)),
+	}, nil
+}
+
+// match returns the index of the first unused entry matching the given
+// request, or -1 if there is none. Entries with the same method and URL are
+// matched, and those with the same request body are preferred, since
+// concurrent requests may have been recorded in any order.
+func (r *Replayer) match(method, url string, body *PostData) int {
+	fallback := -1
+	for i, entry := range r.entries {
+		if r.used[i] || entry.Request.Method != method || entry.Request.URL != url {
+			continue
+		}
+		if body == nil || (entry.Request.PostData != nil &&
+			entry.Request.PostData.Text == body.Text) {
+			return i
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+	return fallback
+}
+
+// Recorded returns true if the trace contains a request with the given
+// method and URL.
+func (r *Replayer) Recorded(method string, u *url.URL) bool {
+	url := redactURL(u)
+	for _, entry := range r.entries {
+		if entry.Request.Method == method && entry.Request.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// RoundTrip responds with the recorded response to the next matching
+// request in the trace.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body *PostData
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read request body: %v", err)
+		}
+		mimeType := req.Header.Get("Content-Type")
+		body = &PostData{
+			MimeType: mimeType,
+			Text:     redactBody(mimeType, string(data)),
+		}
+	}
+	url := redactURL(req.URL)
+	r.mu.Lock()
+	i := r.match(req.Method, url, body)
+	if i >= 0 {
+		r.used[i] = true
+	}
+	r.mu.Unlock()
+	if i < 0 {
+		return nil, fmt.Errorf("couldn't find recorded response to %s %s",
+			req.Method, url)
+	}
+	entry := r.entries[i]
+	if entry.Response.Status == 0 {
+		return nil, errors.New(entry.Comment)
+	}
+	header := http.Header{}
+	for _, h := range entry.Response.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	// the recorded body may have been shortened by redaction
+	header.Del("Content-Length")
+	proto := entry.Response.HTTPVersion
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		proto, major, minor = "HTTP/1.1", 1, 1
+	}
+	return &http.Response{
+		Status: strings.TrimSpace(fmt.Sprintf("%d %s",
+			entry.Response.Status, entry.Response.StatusText)),
+		StatusCode:    entry.Response.Status,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewBufferString(entry.Response.Content.Text)),
+		ContentLength: int64(len(entry.Response.Content.Text)),
+		Request:       req,
+	}, nil
+}
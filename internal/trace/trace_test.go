@@ -0,0 +1,94 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+const secret = "s3cr3t"
+
+func TestRedact(t *testing.T) {
+	var testCases = map[string]struct {
+		mimeType string
+		input    string
+		expect   string
+	}{
+		"json": {
+			mimeType: "application/json",
+			input:    `{"access_token":"` + secret + `","expires_in":3600,"refresh_token": "` + secret + `"}`,
+			expect:   `{"access_token":"REDACTED","expires_in":3600,"refresh_token": "REDACTED"}`,
+		},
+		"json escaped quote": {
+			mimeType: "application/json",
+			input:    `{"password":"a\"` + secret + `","comment":"token"}`,
+			expect:   `{"password":"REDACTED","comment":"token"}`,
+		},
+		"form": {
+			mimeType: "application/x-www-form-urlencoded",
+			input:    "client_secret=" + secret + "&grant_type=refresh_token&refresh_token=" + secret,
+			expect:   "client_secret=REDACTED&grant_type=refresh_token&refresh_token=REDACTED",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			assert.Equal(tt, tc.expect, redactBody(tc.mimeType, tc.input))
+		})
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session="+secret)
+			_, _ = w.Write([]byte(`{"method":"` + r.Method + `","body":"` +
+				string(body) + `","token":"` + secret + `"}`))
+		}))
+	defer ts.Close()
+	rec := NewRecorder("jiratime", "test")
+	c := &http.Client{Transport: rec.Wrap(http.DefaultTransport)}
+	do := func(c *http.Client, method, body string) string {
+		req, err := http.NewRequest(method, ts.URL+"/rest/api/2/issue?token="+secret,
+			strings.NewReader(body))
+		assert.NoError(t, err, "NewRequest")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		req.SetBasicAuth("user", secret)
+		resp, err := c.Do(req)
+		assert.NoError(t, err, "Do")
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err, "ReadAll")
+		return string(data)
+	}
+	first := do(c, http.MethodPost, "first")
+	second := do(c, http.MethodPost, "second")
+	assert.Contains(t, first, secret, "response is not redacted")
+	var buf bytes.Buffer
+	assert.NoError(t, rec.Write(&buf), "Write")
+	assert.NotContains(t, buf.String(), secret, "trace is redacted")
+	var har HAR
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &har), "Unmarshal")
+	assert.Equal(t, 2, len(har.Log.Entries), "entries")
+	// replay out of order
+	rep, err := NewReplayer(&buf)
+	assert.NoError(t, err, "NewReplayer")
+	c = &http.Client{Transport: rep}
+	assert.Equal(t, strings.ReplaceAll(second, secret, redacted),
+		do(c, http.MethodPost, "second"), "second")
+	assert.Equal(t, strings.ReplaceAll(first, secret, redacted),
+		do(c, http.MethodPost, "first"), "first")
+	// entries are only replayed once
+	req, err := http.NewRequest(http.MethodPost,
+		ts.URL+"/rest/api/2/issue?token="+secret, strings.NewReader("first"))
+	assert.NoError(t, err, "NewRequest")
+	_, err = c.Do(req)
+	assert.Error(t, err, "replay exhausted")
+}